@@ -0,0 +1,84 @@
+package hclconfig
+
+import (
+	"sort"
+
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// FunctionInfo describes a function available in the configuration.
+type FunctionInfo struct {
+	Name        string
+	Description string
+	Params      []FunctionParameterInfo
+	// VarParam is the variadic parameter, nil if the function is not variadic.
+	VarParam *FunctionParameterInfo
+	// ReturnType is the return type for the declared parameter types.
+	// It is cty.DynamicPseudoType when the return type depends on the arguments.
+	ReturnType cty.Type
+}
+
+// FunctionParameterInfo describes a parameter of a function.
+type FunctionParameterInfo struct {
+	Name        string
+	Description string
+	Type        cty.Type
+	AllowNull   bool
+	AllowMarked bool
+}
+
+// FunctionCatalog returns the functions available in the configuration, sorted by name.
+func FunctionCatalog() []FunctionInfo {
+	return defaultLoader.FunctionCatalog()
+}
+
+// FunctionCatalog returns the functions available in the configuration, sorted by name.
+// It includes the built-in functions and the functions added with Functions, except the disabled functions.
+// template_include is not included, because it is only available in templates.
+func (l *Loader) FunctionCatalog() []FunctionInfo {
+	functions := l.NewEvalContext().Functions
+	catalog := make([]FunctionInfo, 0, len(functions))
+	for name, f := range functions {
+		if l.isDisabled(name) {
+			continue
+		}
+		catalog = append(catalog, newFunctionInfo(name, f))
+	}
+	sort.Slice(catalog, func(i, j int) bool {
+		return catalog[i].Name < catalog[j].Name
+	})
+	return catalog
+}
+
+func newFunctionInfo(name string, f function.Function) FunctionInfo {
+	info := FunctionInfo{
+		Name:        name,
+		Description: f.Description(),
+		Params:      make([]FunctionParameterInfo, 0, len(f.Params())),
+		ReturnType:  cty.DynamicPseudoType,
+	}
+	argTypes := make([]cty.Type, 0, len(f.Params()))
+	for _, param := range f.Params() {
+		info.Params = append(info.Params, newFunctionParameterInfo(param))
+		argTypes = append(argTypes, param.Type)
+	}
+	if param := f.VarParam(); param != nil {
+		varParam := newFunctionParameterInfo(*param)
+		info.VarParam = &varParam
+	}
+	if ty, err := f.ReturnType(argTypes); err == nil && ty != cty.NilType {
+		info.ReturnType = ty
+	}
+	return info
+}
+
+func newFunctionParameterInfo(param function.Parameter) FunctionParameterInfo {
+	return FunctionParameterInfo{
+		Name:        param.Name,
+		Description: param.Description,
+		Type:        param.Type,
+		AllowNull:   param.AllowNull,
+		AllowMarked: param.AllowMarked,
+	}
+}
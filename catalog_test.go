@@ -0,0 +1,61 @@
+package hclconfig_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/mashiike/hclconfig"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+func TestFunctionCatalog(t *testing.T) {
+	loader := hclconfig.New()
+	loader.Functions(map[string]function.Function{
+		"greet": hclconfig.GoFunc(func(name string, titles ...string) string {
+			return name
+		}),
+	})
+	catalog := loader.FunctionCatalog()
+	require.True(t, sort.SliceIsSorted(catalog, func(i, j int) bool {
+		return catalog[i].Name < catalog[j].Name
+	}))
+	infos := make(map[string]hclconfig.FunctionInfo, len(catalog))
+	for _, info := range catalog {
+		infos[info.Name] = info
+	}
+	require.NotContains(t, infos, "exec")
+
+	env, ok := infos["env"]
+	require.True(t, ok)
+	require.NotEmpty(t, env.Description)
+	require.Len(t, env.Params, 2)
+	require.Equal(t, "key", env.Params[0].Name)
+	require.Equal(t, cty.String, env.Params[0].Type)
+	require.True(t, env.Params[0].AllowMarked)
+	require.False(t, env.Params[0].AllowNull)
+	require.True(t, env.Params[1].AllowNull)
+	require.Nil(t, env.VarParam)
+	require.Equal(t, cty.String, env.ReturnType)
+
+	upper, ok := infos["upper"]
+	require.True(t, ok)
+	require.NotEmpty(t, upper.Description)
+	require.Equal(t, cty.String, upper.ReturnType)
+
+	greet, ok := infos["greet"]
+	require.True(t, ok)
+	require.Len(t, greet.Params, 1)
+	require.NotNil(t, greet.VarParam)
+	require.Equal(t, cty.String, greet.VarParam.Type)
+	require.Equal(t, cty.String, greet.ReturnType)
+
+	loader.EnableExec(hclconfig.ExecOptions{AllowCommands: []string{"echo"}})
+	catalog = loader.FunctionCatalog()
+	names := make([]string, 0, len(catalog))
+	for _, info := range catalog {
+		names = append(names, info.Name)
+	}
+	require.Contains(t, names, "exec")
+}
@@ -0,0 +1,525 @@
+package hclconfig
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+// decodeBodyToValue is the same as gohcl.DecodeBody, except that attributes are decoded with DecodeExpression.
+func decodeBodyToValue(body hcl.Body, ctx *hcl.EvalContext, val reflect.Value) hcl.Diagnostics {
+	et := val.Type()
+	switch et.Kind() {
+	case reflect.Struct:
+		return decodeBodyToStruct(nil, body, ctx, val)
+	case reflect.Map:
+		return decodeBodyToMap(body, ctx, val)
+	case reflect.Pointer:
+		if val.IsNil() {
+			val.Set(reflect.New(et.Elem()))
+		}
+		return decodeBodyToValue(body, ctx, val.Elem())
+	default:
+		panic(fmt.Sprintf("target value must be pointer to struct or map, not %s", et.String()))
+	}
+}
+
+// decodeBodyToStruct decodes the body into the struct. block is the enclosing block, or nil for the root body.
+func decodeBodyToStruct(block *hcl.Block, body hcl.Body, ctx *hcl.EvalContext, val reflect.Value) hcl.Diagnostics {
+	schema, partial := impliedBodySchema(val.Interface())
+	var content *hcl.BodyContent
+	var leftovers hcl.Body
+	var diags hcl.Diagnostics
+	if partial {
+		content, leftovers, diags = body.PartialContent(schema)
+	} else {
+		content, diags = body.Content(schema)
+	}
+	if content == nil {
+		return diags
+	}
+
+	diags = append(diags, applyDefaults(val)...)
+	tags := getFieldTags(val.Type())
+	if tags.Body != nil {
+		field := val.Type().FieldByIndex(tags.Body)
+		fieldV, _ := fieldByIndex(val, tags.Body, true)
+		if bodyType.AssignableTo(field.Type) {
+			fieldV.Set(reflect.ValueOf(body))
+		} else {
+			diags = append(diags, decodeBodyToValue(body, ctx, fieldV)...)
+		}
+	}
+	if tags.Range != nil {
+		field := val.Type().FieldByIndex(tags.Range)
+		fieldV, _ := fieldByIndex(val, tags.Range, true)
+		setSource(field, fieldV, newSource(block, body, content))
+	}
+	if tags.Remain != nil {
+		field := val.Type().FieldByIndex(tags.Remain)
+		fieldV, _ := fieldByIndex(val, tags.Remain, true)
+		p, isPolymorphic := asPolymorphic(fieldV)
+		switch {
+		case isPolymorphic:
+			diags = append(diags, decodePolymorphic(block, leftovers, ctx, p)...)
+		case bodyType.AssignableTo(field.Type):
+			fieldV.Set(reflect.ValueOf(leftovers))
+		case attrsType.AssignableTo(field.Type):
+			attrs, attrsDiags := leftovers.JustAttributes()
+			diags = append(diags, attrsDiags...)
+			fieldV.Set(reflect.ValueOf(attrs))
+		default:
+			diags = append(diags, decodeBodyToValue(leftovers, ctx, fieldV)...)
+		}
+	}
+
+	for name, index := range tags.Attributes {
+		attr := content.Attributes[name]
+		field := val.Type().FieldByIndex(index)
+		if attr == nil {
+			fieldV, ok := fieldByIndex(val, index, false)
+			if ok && assignableTo(exprType, field.Type) {
+				synthExpr := hcl.StaticExpr(cty.NullVal(cty.DynamicPseudoType), body.MissingItemRange())
+				fieldV.Set(reflect.ValueOf(synthExpr))
+			}
+			continue
+		}
+		fieldV, _ := fieldByIndex(val, index, true)
+		switch {
+		case assignableTo(attrType, field.Type):
+			fieldV.Set(reflect.ValueOf(attr))
+		case assignableTo(exprType, field.Type):
+			fieldV.Set(reflect.ValueOf(attr.Expr))
+		default:
+			diags = append(diags, decodeExpression(attr.Expr, ctx, fieldV.Addr().Interface(), attr.Range)...)
+		}
+	}
+
+	blocksByType := content.Blocks.ByType()
+	for typeName, index := range tags.Blocks {
+		blocks := blocksByType[typeName]
+		field := val.Type().FieldByIndex(index)
+		ty := field.Type
+		isMap, isSlice, isPtr := ty.Kind() == reflect.Map, false, false
+		if ty.Kind() == reflect.Slice {
+			isSlice = true
+			ty = ty.Elem()
+		}
+		if ty.Kind() == reflect.Pointer {
+			isPtr = true
+			ty = ty.Elem()
+		}
+		// the required block is checked before the lookup, which fails for the fields promoted through nil embedded pointers.
+		if len(blocks) == 0 && !isMap && !isSlice && !isPtr {
+			diags = append(diags, NewDiagnosticError(
+				fmt.Sprintf("Missing %s block", typeName),
+				fmt.Sprintf("A %s block is required.", typeName),
+				body.MissingItemRange().Ptr(),
+			))
+			continue
+		}
+		fieldV, ok := fieldByIndex(val, index, len(blocks) > 0)
+		if !ok {
+			continue
+		}
+		if isMap {
+			diags = append(diags, decodeBlocksToMap(blocks, ctx, fieldV)...)
+			continue
+		}
+
+		if len(blocks) > 1 && !isSlice {
+			diags = append(diags, NewDiagnosticError(
+				fmt.Sprintf("Duplicate %s block", typeName),
+				fmt.Sprintf("Only one %s block is allowed. Another was defined at %s.", typeName, blocks[0].DefRange.String()),
+				blocks[1].DefRange.Ptr(),
+			))
+			continue
+		}
+		if len(blocks) == 0 {
+			if fieldV.IsNil() {
+				fieldV.Set(reflect.Zero(field.Type))
+			}
+			continue
+		}
+
+		if !isSlice {
+			if !isPtr {
+				diags = append(diags, decodeBlockToValue(blocks[0], ctx, fieldV)...)
+				continue
+			}
+			v := fieldV
+			if v.IsNil() {
+				v = reflect.New(ty)
+			}
+			diags = append(diags, decodeBlockToValue(blocks[0], ctx, v.Elem())...)
+			fieldV.Set(v)
+			continue
+		}
+
+		sli := fieldV
+		if sli.IsNil() {
+			sli = reflect.MakeSlice(field.Type, len(blocks), len(blocks))
+		}
+		for i, block := range blocks {
+			if isPtr {
+				if i >= sli.Len() {
+					sli = reflect.Append(sli, reflect.New(ty))
+				}
+				v := sli.Index(i)
+				if v.IsNil() {
+					v = reflect.New(ty)
+				}
+				diags = append(diags, decodeBlockToValue(block, ctx, v.Elem())...)
+				sli.Index(i).Set(v)
+			} else {
+				if i >= sli.Len() {
+					sli = reflect.Append(sli, reflect.Indirect(reflect.New(ty)))
+				}
+				diags = append(diags, decodeBlockToValue(block, ctx, sli.Index(i))...)
+			}
+		}
+		if sli.Len() > len(blocks) {
+			sli.SetLen(len(blocks))
+		}
+		fieldV.Set(sli)
+	}
+	return diags
+}
+
+// assignableTo reports whether the hcl type is assigned to the field as is.
+// The empty interfaces are excluded, because they are decoded from the values.
+func assignableTo(hclType reflect.Type, ty reflect.Type) bool {
+	if ty.Kind() == reflect.Interface && ty.NumMethod() == 0 {
+		return false
+	}
+	return hclType.AssignableTo(ty)
+}
+
+func decodeBodyToMap(body hcl.Body, ctx *hcl.EvalContext, v reflect.Value) hcl.Diagnostics {
+	attrs, diags := body.JustAttributes()
+	if attrs == nil {
+		return diags
+	}
+	mv := reflect.MakeMap(v.Type())
+	for k, attr := range attrs {
+		key := reflect.ValueOf(k).Convert(v.Type().Key())
+		switch {
+		case assignableTo(attrType, v.Type().Elem()):
+			mv.SetMapIndex(key, reflect.ValueOf(attr))
+		case assignableTo(exprType, v.Type().Elem()):
+			mv.SetMapIndex(key, reflect.ValueOf(attr.Expr))
+		default:
+			ev := reflect.New(v.Type().Elem())
+			diags = append(diags, decodeExpression(attr.Expr, ctx, ev.Interface(), attr.Range)...)
+			mv.SetMapIndex(key, ev.Elem())
+		}
+	}
+	v.Set(mv)
+	return diags
+}
+
+func decodeBlockToValue(block *hcl.Block, ctx *hcl.EvalContext, v reflect.Value) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if v.Kind() == reflect.Struct {
+		diags = decodeBodyToStruct(block, block.Body, ctx, v)
+	} else {
+		diags = decodeBodyToValue(block.Body, ctx, v)
+	}
+	if len(block.Labels) > 0 {
+		blockTags := getFieldTags(v.Type())
+		for li, lv := range block.Labels {
+			if li >= len(blockTags.Labels) {
+				break
+			}
+			labelV, _ := fieldByIndex(v, blockTags.Labels[li].FieldIndex, true)
+			labelV.Set(reflect.ValueOf(lv).Convert(labelV.Type()))
+		}
+	}
+	return diags
+}
+
+// decodeBlocksToMap decodes the blocks into the nested maps keyed by the labels.
+// The duplicated labels are reported in the same way as RestrictUniqueBlockLabels.
+func decodeBlocksToMap(blocks hcl.Blocks, ctx *hcl.EvalContext, val reflect.Value) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if len(blocks) == 0 {
+		return diags
+	}
+	m := reflect.MakeMap(val.Type())
+	declared := make(map[string]*hcl.Range, len(blocks))
+	for _, block := range blocks {
+		key := strings.Join(block.Labels, ".")
+		if r, ok := declared[key]; ok {
+			diags = append(diags, duplicateBlockDiagnostic(block, r))
+			continue
+		}
+		declared[key] = block.DefRange.Ptr()
+
+		current := m
+		for _, label := range block.Labels[:len(block.Labels)-1] {
+			key := reflect.ValueOf(label).Convert(current.Type().Key())
+			next := current.MapIndex(key)
+			if !next.IsValid() {
+				next = reflect.MakeMap(current.Type().Elem())
+				current.SetMapIndex(key, next)
+			}
+			current = next
+		}
+		elemType := current.Type().Elem()
+		v := reflect.New(elemType).Elem()
+		if elemType.Kind() == reflect.Pointer {
+			v.Set(reflect.New(elemType.Elem()))
+			diags = append(diags, decodeBlockToValue(block, ctx, v.Elem())...)
+		} else {
+			diags = append(diags, decodeBlockToValue(block, ctx, v)...)
+		}
+		current.SetMapIndex(reflect.ValueOf(block.Labels[len(block.Labels)-1]).Convert(current.Type().Key()), v)
+	}
+	val.Set(m)
+	return diags
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+	urlType             = reflect.TypeOf(url.URL{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// needsValueDecoder reports whether the type contains a type that gocty can not decode,
+// such as time.Duration, an encoding.TextUnmarshaler or an interface.
+func needsValueDecoder(ty reflect.Type) bool {
+	switch ty {
+	case durationType, timeType, urlType:
+		return true
+	}
+	if reflect.PointerTo(ty).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch ty.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return needsValueDecoder(ty.Elem())
+	case reflect.Map:
+		return ty.Key().Kind() == reflect.String && needsValueDecoder(ty.Elem())
+	}
+	return false
+}
+
+// decodeExpression is DecodeExpression reporting conversion errors on subject.
+func decodeExpression(expr hcl.Expression, ctx *hcl.EvalContext, val interface{}, subject hcl.Range) hcl.Diagnostics {
+	if decoder, ok := val.(ExpressionDecoder); ok {
+		return decoder.DecodeExpression(expr, ctx)
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Pointer {
+		panic(fmt.Errorf("given value must be pointer, not %T", val))
+	}
+	if elem := rv.Elem(); elem.Kind() == reflect.Pointer && elem.Type().Implements(expressionDecoderType) {
+		if elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+		return elem.Interface().(ExpressionDecoder).DecodeExpression(expr, ctx)
+	}
+	if !needsValueDecoder(rv.Elem().Type()) {
+		return gohcl.DecodeExpression(expr, ctx, val)
+	}
+	value, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		return diags
+	}
+	value, _ = value.UnmarkDeep()
+	return append(diags, decodeValue(value, rv.Elem(), subject)...)
+}
+
+// decodeValue decodes the value into rv, with the support of the standard library types.
+func decodeValue(value cty.Value, rv reflect.Value, subject hcl.Range) hcl.Diagnostics {
+	if !value.IsWhollyKnown() {
+		return nil
+	}
+	ty := rv.Type()
+	if value.IsNull() {
+		rv.Set(reflect.Zero(ty))
+		return nil
+	}
+	switch ty {
+	case durationType:
+		return decodeDurationValue(value, rv.Addr().Interface().(*time.Duration), subject)
+	case timeType:
+		return decodeTimeValue(value, rv.Addr().Interface().(*time.Time), subject)
+	case urlType:
+		return decodeURLValue(value, rv.Addr().Interface().(*url.URL), subject)
+	}
+	if u, ok := rv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return decodeTextValue(value, u, subject)
+	}
+	if !needsValueDecoder(ty) {
+		return decodeGoValue(value, rv, subject)
+	}
+
+	var diags hcl.Diagnostics
+	switch ty.Kind() {
+	case reflect.Interface:
+		v := ctyValueToInterface(value)
+		if v == nil || !reflect.TypeOf(v).AssignableTo(ty) {
+			break
+		}
+		rv.Set(reflect.ValueOf(v))
+		return diags
+	case reflect.Pointer:
+		v := reflect.New(ty.Elem())
+		diags = append(diags, decodeValue(value, v.Elem(), subject)...)
+		rv.Set(v)
+		return diags
+	case reflect.Slice, reflect.Array:
+		vty := value.Type()
+		if !vty.IsListType() && !vty.IsTupleType() && !vty.IsSetType() {
+			break
+		}
+		length := value.LengthInt()
+		if ty.Kind() == reflect.Slice {
+			rv.Set(reflect.MakeSlice(ty, length, length))
+		} else if length != rv.Len() {
+			diags = append(diags, NewDiagnosticError(
+				"Unsuitable value type",
+				fmt.Sprintf("Unsuitable value: %d elements are required, not %d", rv.Len(), length),
+				subject.Ptr(),
+			))
+			return diags
+		}
+		i := 0
+		for it := value.ElementIterator(); it.Next(); i++ {
+			_, elem := it.Element()
+			diags = append(diags, decodeValue(elem, rv.Index(i), subject)...)
+		}
+		return diags
+	case reflect.Map:
+		vty := value.Type()
+		if !vty.IsMapType() && !vty.IsObjectType() {
+			break
+		}
+		m := reflect.MakeMapWithSize(ty, value.LengthInt())
+		for it := value.ElementIterator(); it.Next(); {
+			key, elem := it.Element()
+			v := reflect.New(ty.Elem()).Elem()
+			diags = append(diags, decodeValue(elem, v, subject)...)
+			m.SetMapIndex(reflect.ValueOf(key.AsString()).Convert(ty.Key()), v)
+		}
+		rv.Set(m)
+		return diags
+	}
+	diags = append(diags, NewDiagnosticError(
+		"Unsuitable value type",
+		fmt.Sprintf("Unsuitable value: %s can not be decoded into %s", value.Type().FriendlyName(), ty.String()),
+		subject.Ptr(),
+	))
+	return diags
+}
+
+// decodeGoValue decodes the value with gocty, in the same way as gohcl.DecodeExpression.
+func decodeGoValue(value cty.Value, rv reflect.Value, subject hcl.Range) hcl.Diagnostics {
+	return gohcl.DecodeExpression(hcl.StaticExpr(value, subject), nil, rv.Addr().Interface())
+}
+
+// decodeDurationValue decodes a duration string such as "30s", or a number of seconds.
+func decodeDurationValue(value cty.Value, d *time.Duration, subject hcl.Range) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	switch value.Type() {
+	case cty.Number:
+		f, _ := value.AsBigFloat().Float64()
+		*d = time.Duration(f * float64(time.Second))
+		return diags
+	case cty.String:
+		parsed, err := time.ParseDuration(value.AsString())
+		if err != nil {
+			diags = append(diags, NewDiagnosticError("Invalid duration", err.Error(), subject.Ptr()))
+			return diags
+		}
+		*d = parsed
+		return diags
+	}
+	diags = append(diags, NewDiagnosticError(
+		"Invalid duration",
+		fmt.Sprintf("A duration string or a number of seconds is required, not %s", value.Type().FriendlyName()),
+		subject.Ptr(),
+	))
+	return diags
+}
+
+// decodeTimeValue decodes a RFC3339 timestamp string, or a number of unix seconds.
+func decodeTimeValue(value cty.Value, t *time.Time, subject hcl.Range) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	switch value.Type() {
+	case cty.Number:
+		f, _ := value.AsBigFloat().Float64()
+		*t = unixSecondsToTime(f)
+		return diags
+	case cty.String:
+		parsed, err := time.Parse(time.RFC3339, value.AsString())
+		if err != nil {
+			diags = append(diags, NewDiagnosticError("Invalid time", err.Error(), subject.Ptr()))
+			return diags
+		}
+		*t = parsed
+		return diags
+	}
+	diags = append(diags, NewDiagnosticError(
+		"Invalid time",
+		fmt.Sprintf("A RFC3339 timestamp string or a number of unix seconds is required, not %s", value.Type().FriendlyName()),
+		subject.Ptr(),
+	))
+	return diags
+}
+
+// decodeURLValue decodes a URL string.
+func decodeURLValue(value cty.Value, u *url.URL, subject hcl.Range) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if value.Type() != cty.String {
+		diags = append(diags, NewDiagnosticError(
+			"Invalid URL",
+			fmt.Sprintf("A URL string is required, not %s", value.Type().FriendlyName()),
+			subject.Ptr(),
+		))
+		return diags
+	}
+	parsed, err := url.Parse(value.AsString())
+	if err != nil {
+		diags = append(diags, NewDiagnosticError("Invalid URL", err.Error(), subject.Ptr()))
+		return diags
+	}
+	*u = *parsed
+	return diags
+}
+
+// decodeTextValue decodes a string with encoding.TextUnmarshaler.
+func decodeTextValue(value cty.Value, u encoding.TextUnmarshaler, subject hcl.Range) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if converted, err := convert.Convert(value, cty.String); err == nil {
+		value = converted
+	}
+	if value.Type() != cty.String {
+		diags = append(diags, NewDiagnosticError(
+			"Unsuitable value type",
+			fmt.Sprintf("A string is required to decode %T, not %s", u, value.Type().FriendlyName()),
+			subject.Ptr(),
+		))
+		return diags
+	}
+	if err := u.UnmarshalText([]byte(value.AsString())); err != nil {
+		diags = append(diags, NewDiagnosticError(
+			"Invalid value",
+			fmt.Sprintf("Can not decode %q into %T: %s", value.AsString(), u, err),
+			subject.Ptr(),
+		))
+	}
+	return diags
+}
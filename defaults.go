@@ -0,0 +1,147 @@
+package hclconfig
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/gocty"
+)
+
+// Defaulter is an interface for setting default values.
+// SetDefaults is called before decoding the body, so the attributes in the body overwrite the defaults.
+type Defaulter interface {
+	SetDefaults()
+}
+
+// defaultTagValue returns the value of the default tag of the field.
+// The tag of a string field is the literal string, the others are parsed as HCL expressions.
+// If the tag is not a valid expression, such as "30s", it is treated as a string.
+func defaultTagValue(field reflect.StructField) (cty.Value, bool) {
+	tag, ok := field.Tag.Lookup("default")
+	if !ok {
+		return cty.NilVal, false
+	}
+	ty := field.Type
+	if ty.Kind() == reflect.Pointer {
+		ty = ty.Elem()
+	}
+	if ty.Kind() == reflect.String {
+		return cty.StringVal(tag), true
+	}
+	expr, diags := hclsyntax.ParseExpression([]byte(tag), "default", hcl.InitialPos)
+	if diags.HasErrors() {
+		return cty.StringVal(tag), true
+	}
+	value, diags := expr.Value(nil)
+	if diags.HasErrors() {
+		return cty.StringVal(tag), true
+	}
+	return value, true
+}
+
+var defaulterType = reflect.TypeOf((*Defaulter)(nil)).Elem()
+
+// applyDefaults sets the default tag values to the zero value fields, and calls SetDefaults if val is a Defaulter.
+// If val is not a Defaulter, SetDefaults of the embedded structs are called.
+func applyDefaults(val reflect.Value) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	ty := val.Type()
+	tags := getFieldTags(ty)
+	for _, name := range sortedFieldNames(tags.Attributes) {
+		index := tags.Attributes[name]
+		field := ty.FieldByIndex(index)
+		value, ok := defaultTagValue(field)
+		if !ok {
+			continue
+		}
+		fieldV, _ := fieldByIndex(val, index, true)
+		if !fieldV.IsZero() {
+			continue
+		}
+		if decodeDiags := decodeValue(value, fieldV, hcl.Range{Filename: "default"}); decodeDiags.HasErrors() {
+			diags = append(diags, NewDiagnosticError(
+				"Invalid default tag",
+				fmt.Sprintf("The default tag %q on %s field %s (attribute %q) is invalid: %s", field.Tag.Get("default"), ty.String(), field.Name, name, decodeDiags.Error()),
+				nil,
+			))
+		}
+	}
+	for _, d := range implementations(val, defaulterType) {
+		d.(Defaulter).SetDefaults()
+	}
+	return diags
+}
+
+// defaultVariables returns the default values of the attributes of the struct type as cty values.
+// Durations are numbers of seconds as the decoded ones, even if the default tag is a string such as "30s".
+// The invalid default tags are skipped, since they are reported by the decoding.
+func defaultVariables(ty reflect.Type) map[string]cty.Value {
+	val := reflect.New(ty).Elem()
+	applyDefaults(val)
+	tags := getFieldTags(ty)
+	variables := make(map[string]cty.Value, len(tags.Attributes))
+	for name, index := range tags.Attributes {
+		field := ty.FieldByIndex(index)
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if value, ok := defaultTagValue(field); ok {
+			if decodeValue(value, reflect.New(field.Type).Elem(), hcl.Range{Filename: "default"}).HasErrors() {
+				continue
+			}
+			if fieldType != durationType {
+				variables[name] = value
+				continue
+			}
+		}
+		fieldV, ok := fieldByIndex(val, index, false)
+		if !ok || fieldV.IsZero() {
+			continue
+		}
+		if value, ok := goValueToCtyValue(fieldV); ok {
+			variables[name] = value
+		}
+	}
+	return variables
+}
+
+// goValueToCtyValue converts the Go value to the cty value, in the representation accepted by DecodeExpression.
+// The pointers are converted as the values they point to.
+func goValueToCtyValue(rv reflect.Value) (cty.Value, bool) {
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return cty.NilVal, false
+		}
+		rv = rv.Elem()
+	}
+	switch v := rv.Interface().(type) {
+	case time.Duration:
+		return cty.NumberFloatVal(v.Seconds()), true
+	case time.Time:
+		return cty.StringVal(v.Format(time.RFC3339)), true
+	case url.URL:
+		return cty.StringVal(v.String()), true
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		if err != nil {
+			return cty.NilVal, false
+		}
+		return cty.StringVal(string(text)), true
+	}
+	ty, err := gocty.ImpliedType(rv.Interface())
+	if err != nil {
+		return cty.NilVal, false
+	}
+	value, err := gocty.ToCtyValue(rv.Interface(), ty)
+	if err != nil {
+		return cty.NilVal, false
+	}
+	return value, true
+}
@@ -2,13 +2,11 @@ package hclconfig_test
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/mashiike/hclconfig"
 )
 
 func Example() {
-	os.Setenv("HCLCONFIG_VAR", "hoge")
 	type ExampleConfig struct {
 		Value  string `hcl:"value"`
 		Groups []*struct {
@@ -17,8 +15,12 @@ func Example() {
 			Value int    `hcl:"value"`
 		} `hcl:"group,block"`
 	}
+	loader := hclconfig.New()
+	loader.Environment(map[string]string{
+		"HCLCONFIG_VAR": "hoge",
+	})
 	var cfg ExampleConfig
-	err := hclconfig.LoadWithBytes(&cfg, "config.hcl", []byte(`
+	err := loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
 value = must_env("HCLCONFIG_VAR")
 
 group "type1" "default" {
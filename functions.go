@@ -1,12 +1,18 @@
 package hclconfig
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Songmu/flextime"
@@ -21,70 +27,78 @@ import (
 )
 
 var defaultFunctions = map[string]function.Function{
-	"abs":              stdlib.AbsoluteFunc,
-	"add":              stdlib.AddFunc,
-	"can":              tryfunc.CanFunc,
-	"ceil":             stdlib.CeilFunc,
-	"chomp":            stdlib.ChompFunc,
-	"coalesce":         stdlib.CoalesceFunc,
-	"coalescelist":     stdlib.CoalesceListFunc,
-	"compact":          stdlib.CompactFunc,
-	"concat":           stdlib.ConcatFunc,
-	"contains":         stdlib.ContainsFunc,
-	"csvdecode":        stdlib.CSVDecodeFunc,
-	"duration":         DurationFunc,
-	"distinct":         stdlib.DistinctFunc,
-	"element":          stdlib.ElementFunc,
-	"env":              EnvFunc,
-	"chunklist":        stdlib.ChunklistFunc,
-	"flatten":          stdlib.FlattenFunc,
-	"floor":            stdlib.FloorFunc,
-	"format":           stdlib.FormatFunc,
-	"formatdate":       stdlib.FormatDateFunc,
-	"formatlist":       stdlib.FormatListFunc,
-	"indent":           stdlib.IndentFunc,
-	"index":            stdlib.IndexFunc,
-	"join":             stdlib.JoinFunc,
-	"jsondecode":       stdlib.JSONDecodeFunc,
-	"jsonencode":       stdlib.JSONEncodeFunc,
-	"keys":             stdlib.KeysFunc,
-	"log":              stdlib.LogFunc,
-	"lower":            stdlib.LowerFunc,
-	"max":              stdlib.MaxFunc,
-	"merge":            stdlib.MergeFunc,
-	"min":              stdlib.MinFunc,
-	"must_env":         MustEnvFunc,
-	"now":              NowFunc,
-	"parseint":         stdlib.ParseIntFunc,
-	"pow":              stdlib.PowFunc,
-	"range":            stdlib.RangeFunc,
-	"regex":            stdlib.RegexFunc,
-	"regexall":         stdlib.RegexAllFunc,
-	"reverse":          stdlib.ReverseListFunc,
-	"setintersection":  stdlib.SetIntersectionFunc,
-	"setproduct":       stdlib.SetProductFunc,
-	"setsubtract":      stdlib.SetSubtractFunc,
-	"setunion":         stdlib.SetUnionFunc,
-	"signum":           stdlib.SignumFunc,
-	"strftime":         StrftimeFunc,
-	"strftime_in_zone": StrftimeInZoneFunc,
-	"slice":            stdlib.SliceFunc,
-	"sort":             stdlib.SortFunc,
-	"split":            stdlib.SplitFunc,
-	"strrev":           stdlib.ReverseFunc,
-	"substr":           stdlib.SubstrFunc,
-	"timeadd":          stdlib.TimeAddFunc,
-	"title":            stdlib.TitleFunc,
-	"trim":             stdlib.TrimFunc,
-	"trimprefix":       stdlib.TrimPrefixFunc,
-	"trimspace":        stdlib.TrimSpaceFunc,
-	"trimsuffix":       stdlib.TrimSuffixFunc,
-	"try":              tryfunc.TryFunc,
-	"upper":            stdlib.UpperFunc,
-	"values":           stdlib.ValuesFunc,
-	"yamldecode":       ctyyaml.YAMLDecodeFunc,
-	"yamlencode":       ctyyaml.YAMLEncodeFunc,
-	"zipmap":           stdlib.ZipmapFunc,
+	"abs":                     stdlib.AbsoluteFunc,
+	"add":                     stdlib.AddFunc,
+	"can":                     tryfunc.CanFunc,
+	"ceil":                    stdlib.CeilFunc,
+	"chomp":                   stdlib.ChompFunc,
+	"coalesce":                stdlib.CoalesceFunc,
+	"coalescelist":            stdlib.CoalesceListFunc,
+	"compact":                 stdlib.CompactFunc,
+	"concat":                  stdlib.ConcatFunc,
+	"contains":                stdlib.ContainsFunc,
+	"csvdecode":               stdlib.CSVDecodeFunc,
+	"duration":                DurationFunc,
+	"distinct":                stdlib.DistinctFunc,
+	"element":                 stdlib.ElementFunc,
+	"env":                     EnvFunc,
+	"chunklist":               stdlib.ChunklistFunc,
+	"flatten":                 stdlib.FlattenFunc,
+	"floor":                   stdlib.FloorFunc,
+	"format":                  stdlib.FormatFunc,
+	"formatdate":              stdlib.FormatDateFunc,
+	"formatlist":              stdlib.FormatListFunc,
+	"indent":                  stdlib.IndentFunc,
+	"index":                   stdlib.IndexFunc,
+	"join":                    stdlib.JoinFunc,
+	"jsondecode":              stdlib.JSONDecodeFunc,
+	"jsonencode":              stdlib.JSONEncodeFunc,
+	"keys":                    stdlib.KeysFunc,
+	"log":                     stdlib.LogFunc,
+	"lower":                   stdlib.LowerFunc,
+	"max":                     stdlib.MaxFunc,
+	"merge":                   stdlib.MergeFunc,
+	"min":                     stdlib.MinFunc,
+	"must_env":                MustEnvFunc,
+	"now":                     NowFunc,
+	"parseint":                stdlib.ParseIntFunc,
+	"pow":                     stdlib.PowFunc,
+	"range":                   stdlib.RangeFunc,
+	"regex":                   stdlib.RegexFunc,
+	"regexall":                stdlib.RegexAllFunc,
+	"reverse":                 stdlib.ReverseListFunc,
+	"setintersection":         stdlib.SetIntersectionFunc,
+	"setproduct":              stdlib.SetProductFunc,
+	"setsubtract":             stdlib.SetSubtractFunc,
+	"setunion":                stdlib.SetUnionFunc,
+	"signum":                  stdlib.SignumFunc,
+	"strftime":                StrftimeFunc,
+	"strftime_in_zone":        StrftimeInZoneFunc,
+	"slice":                   stdlib.SliceFunc,
+	"sort":                    stdlib.SortFunc,
+	"split":                   stdlib.SplitFunc,
+	"strrev":                  stdlib.ReverseFunc,
+	"substr":                  stdlib.SubstrFunc,
+	"timeadd":                 stdlib.TimeAddFunc,
+	"timecmp":                 TimeCmpFunc,
+	"timeparse":               TimeParseFunc,
+	"timeparse_in_zone":       TimeParseInZoneFunc,
+	"timetrunc":               TimeTruncFunc,
+	"title":                   stdlib.TitleFunc,
+	"trim":                    stdlib.TrimFunc,
+	"trimprefix":              stdlib.TrimPrefixFunc,
+	"trimspace":               stdlib.TrimSpaceFunc,
+	"trimsuffix":              stdlib.TrimSuffixFunc,
+	"try":                     tryfunc.TryFunc,
+	"unix_to_rfc3339":         UnixToRFC3339Func,
+	"unix_to_rfc3339_in_zone": UnixToRFC3339InZoneFunc,
+	"upper":                   stdlib.UpperFunc,
+	"values":                  stdlib.ValuesFunc,
+	"weekday":                 WeekdayFunc,
+	"weekday_in_zone":         WeekdayInZoneFunc,
+	"yamldecode":              ctyyaml.YAMLDecodeFunc,
+	"yamlencode":              ctyyaml.YAMLEncodeFunc,
+	"zipmap":                  stdlib.ZipmapFunc,
 }
 
 func mergeFunctions(dst map[string]function.Function, src map[string]function.Function) map[string]function.Function {
@@ -97,79 +111,224 @@ func mergeFunctions(dst map[string]function.Function, src map[string]function.Fu
 	return dst
 }
 
-var MustEnvFunc = function.New(&function.Spec{
-	Params: []function.Parameter{
-		{
-			Name:        "key",
-			Type:        cty.String,
-			AllowMarked: true,
+// impureFunctions is the functions disabled in the pure mode.
+var impureFunctions = map[string]bool{
+	"env":          true,
+	"must_env":     true,
+	"exec":         true,
+	"file":         true,
+	"now":          true,
+	"templatefile": true,
+}
+
+// currentTimeParams is the indexes of the parameters that default to the current time when null.
+var currentTimeParams = map[string][]int{
+	"strftime":                {1},
+	"strftime_in_zone":        {2},
+	"timecmp":                 {0, 1},
+	"timetrunc":               {0},
+	"unix_to_rfc3339":         {0},
+	"unix_to_rfc3339_in_zone": {1},
+	"weekday":                 {0},
+	"weekday_in_zone":         {1},
+}
+
+// makeDisabledFunc returns a function that always fails, in place of the function disabled in the mode.
+func makeDisabledFunc(name string, mode string) function.Function {
+	return function.New(&function.Spec{
+		Description: fmt.Sprintf("%s is disabled in %s.", name, mode),
+		VarParam: &function.Parameter{
+			Name:             "args",
+			Type:             cty.DynamicPseudoType,
+			AllowNull:        true,
+			AllowUnknown:     true,
+			AllowDynamicType: true,
+			AllowMarked:      true,
 		},
-	},
-	Type: function.StaticReturnType(cty.String),
-	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
-		keyArg, keyMarks := args[0].Unmark()
-		key := keyArg.AsString()
-		value := os.Getenv(key)
-		if value == "" {
-			err := function.NewArgError(0, fmt.Errorf("env `%s` is not set", key))
-			return cty.UnknownVal(cty.String), err
-		}
-		return cty.StringVal(value).WithMarks(keyMarks), nil
-	},
-})
+		Type: func(args []cty.Value) (cty.Type, error) {
+			return cty.NilType, fmt.Errorf("%s is disabled in %s", name, mode)
+		},
+	})
+}
 
-var EnvFunc = function.New(&function.Spec{
-	Params: []function.Parameter{
-		{
-			Name:        "key",
-			Type:        cty.String,
-			AllowMarked: true,
+// withoutCurrentTime wraps the function to reject null for the parameters that default to the current time.
+func withoutCurrentTime(f function.Function, params ...int) function.Function {
+	return function.New(&function.Spec{
+		Description: f.Description(),
+		Params:      f.Params(),
+		VarParam:    f.VarParam(),
+		Type: func(args []cty.Value) (cty.Type, error) {
+			for _, i := range params {
+				if i < len(args) && args[i].IsNull() {
+					return cty.NilType, function.NewArgError(i, errors.New("the current time is not available in pure mode, the time must be given"))
+				}
+			}
+			return f.ReturnTypeForValues(args)
 		},
-		{
-			Name:      "default",
-			Type:      cty.String,
-			AllowNull: true,
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			return f.Call(args)
 		},
-	},
-	Type: function.StaticReturnType(cty.String),
-	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
-		keyArg, keyMarks := args[0].Unmark()
-		key := keyArg.AsString()
-		if value := os.Getenv(key); value != "" {
+	})
+}
+
+var MustEnvFunc = MakeMustEnvFunc(os.LookupEnv)
+
+// MakeMustEnvFunc returns the must_env function, that reads environment variables with lookupEnv.
+func MakeMustEnvFunc(lookupEnv func(string) (string, bool)) function.Function {
+	return makeMustEnvFunc(lookupEnv, nil)
+}
+
+func makeMustEnvFunc(lookupEnv func(string) (string, bool), rec *loadRecorder) function.Function {
+	return function.New(&function.Spec{
+		Description: "Returns the value of the environment variable, and fails if it is not set or empty.",
+		Params: []function.Parameter{
+			{
+				Name:        "key",
+				Description: "The name of the environment variable.",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			keyArg, keyMarks := args[0].Unmark()
+			key := keyArg.AsString()
+			value, _ := lookupEnv(key)
+			rec.env(key, value != "")
+			if value == "" {
+				err := function.NewArgError(0, fmt.Errorf("env `%s` is not set", key))
+				return cty.UnknownVal(cty.String), err
+			}
 			return cty.StringVal(value).WithMarks(keyMarks), nil
-		}
-		if args[1].IsNull() {
-			return cty.StringVal("").WithMarks(keyMarks), nil
-		}
-		return cty.StringVal(args[1].AsString()).WithMarks(keyMarks), nil
-	},
-})
+		},
+	})
+}
 
-func openFile(path string, basePaths ...string) ([]byte, error) {
-	var targetPath string
+var EnvFunc = MakeEnvFunc(os.LookupEnv)
+
+// MakeEnvFunc returns the env function, that reads environment variables with lookupEnv.
+func MakeEnvFunc(lookupEnv func(string) (string, bool)) function.Function {
+	return makeEnvFunc(lookupEnv, nil)
+}
+
+func makeEnvFunc(lookupEnv func(string) (string, bool), rec *loadRecorder) function.Function {
+	return function.New(&function.Spec{
+		Description: "Returns the value of the environment variable, or the default value if it is not set or empty.",
+		Params: []function.Parameter{
+			{
+				Name:        "key",
+				Description: "The name of the environment variable.",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+			{
+				Name:        "default",
+				Description: "The value returned when the environment variable is not set.",
+				Type:        cty.String,
+				AllowNull:   true,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			keyArg, keyMarks := args[0].Unmark()
+			key := keyArg.AsString()
+			value, ok := lookupEnv(key)
+			rec.env(key, ok && value != "")
+			if ok && value != "" {
+				return cty.StringVal(value).WithMarks(keyMarks), nil
+			}
+			if args[1].IsNull() {
+				return cty.StringVal("").WithMarks(keyMarks), nil
+			}
+			return cty.StringVal(args[1].AsString()).WithMarks(keyMarks), nil
+		},
+	})
+}
+
+// fileResolver resolves the paths given to the file and templatefile functions.
+type fileResolver struct {
+	basePaths []string
+
+	// sandbox confines the resolved paths to the basePaths and the allowedRoots.
+	sandbox      bool
+	allowedRoots []string
+
+	// rec records the opened files, if set.
+	rec *loadRecorder
+}
+
+func (r *fileResolver) resolve(path string) (string, error) {
+	var candidates []string
 	if filepath.IsAbs(path) {
-		targetPath = path
+		candidates = []string{path}
 	} else {
-		if wd, err := os.Getwd(); err == nil {
-			basePaths = append(basePaths, wd)
+		basePaths := r.basePaths
+		if !r.sandbox {
+			if wd, err := os.Getwd(); err == nil {
+				basePaths = append(basePaths[:len(basePaths):len(basePaths)], wd)
+			}
 		}
 		for _, basePath := range basePaths {
-			path := filepath.Join(basePath, path)
-			if _, err := os.Stat(path); err != nil {
-				continue
-			}
-			targetPath = path
-			break
+			candidates = append(candidates, filepath.Join(basePath, path))
 		}
 	}
-	if targetPath == "" {
-		return nil, fmt.Errorf("%s not found", path)
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err != nil {
+			continue
+		}
+		if r.sandbox {
+			// the checked real path is returned, so that a symlink swapped after the check is not followed.
+			return r.checkSandbox(path, candidate)
+		}
+		return candidate, nil
+	}
+	return "", fmt.Errorf("%s not found", path)
+}
+
+// checkSandbox returns the real path of the candidate if it is inside of the allowed roots.
+func (r *fileResolver) checkSandbox(path string, candidate string) (string, error) {
+	target, err := realPath(candidate)
+	if err != nil {
+		return "", err
+	}
+	roots := make([]string, 0, len(r.basePaths)+len(r.allowedRoots))
+	roots = append(roots, r.basePaths...)
+	roots = append(roots, r.allowedRoots...)
+	for _, root := range roots {
+		realRoot, err := realPath(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(realRoot, target)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return target, nil
+		}
+	}
+	return "", fmt.Errorf("%s is outside of the allowed roots, reading it is not permitted in sandbox mode", path)
+}
+
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+func (r *fileResolver) open(path string) ([]byte, error) {
+	targetPath, err := r.resolve(path)
+	if err != nil {
+		return nil, err
 	}
 	fp, err := os.Open(targetPath)
 	if err != nil {
 		return nil, err
 	}
 	defer fp.Close()
+	r.rec.file(targetPath)
 	bs, err := io.ReadAll(fp)
 	if err != nil {
 		return nil, err
@@ -177,11 +336,22 @@ func openFile(path string, basePaths ...string) ([]byte, error) {
 	return bs, nil
 }
 
+func openFile(path string, basePaths ...string) ([]byte, error) {
+	r := &fileResolver{basePaths: basePaths}
+	return r.open(path)
+}
+
 func MakeFileFunc(basePaths ...string) function.Function {
+	return makeFileFunc(&fileResolver{basePaths: basePaths})
+}
+
+func makeFileFunc(r *fileResolver) function.Function {
 	return function.New(&function.Spec{
+		Description: "Reads the contents of a file as a string.",
 		Params: []function.Parameter{
 			{
 				Name:        "path",
+				Description: "The path of the file, relative to the directory of the configuration files.",
 				Type:        cty.String,
 				AllowMarked: true,
 			},
@@ -189,7 +359,7 @@ func MakeFileFunc(basePaths ...string) function.Function {
 		Type: function.StaticReturnType(cty.String),
 		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
 			pathArg, pathMarks := args[0].Unmark()
-			content, err := openFile(pathArg.AsString(), basePaths...)
+			content, err := r.open(pathArg.AsString())
 			if err != nil {
 				err = function.NewArgError(0, err)
 				return cty.UnknownVal(cty.String), err
@@ -199,60 +369,435 @@ func MakeFileFunc(basePaths ...string) function.Function {
 	})
 }
 
+// DefaultTemplateMaxDepth is the default limit of the nesting depth of templatefile and template_include.
+const DefaultTemplateMaxDepth = 10
+
 func MakeTemplateFileFunc(newEvalContext func() *hcl.EvalContext, basePaths ...string) function.Function {
-	render := func(args []cty.Value) (cty.Value, error) {
-		if len(args) != 2 {
-			return cty.UnknownVal(cty.DynamicPseudoType), errors.New("require argument length 2")
+	return makeTemplateFileFunc(&templateRenderer{
+		newEvalContext: newEvalContext,
+		resolver:       &fileResolver{basePaths: basePaths},
+		maxDepth:       DefaultTemplateMaxDepth,
+	})
+}
+
+func makeTemplateFileFunc(t *templateRenderer) function.Function {
+	return function.New(&function.Spec{
+		Description: "Renders a template file with the given variables.",
+		Params: []function.Parameter{
+			{
+				Name:        "path",
+				Description: "The path of the template file.",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+			{
+				Name:        "variables",
+				Description: "An object of the variables available in the template.",
+				Type:        cty.DynamicPseudoType,
+			},
+		},
+		Type: function.StaticReturnType(cty.DynamicPseudoType),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			return t.renderArgs("", args, nil)
+		},
+	})
+}
+
+// templateRenderer renders template files for templatefile and template_include.
+type templateRenderer struct {
+	newEvalContext func() *hcl.EvalContext
+	resolver       *fileResolver
+	// fsys is used instead of resolver to read templates, if set.
+	fsys     fs.FS
+	maxDepth int
+	// strict isolates templates from the variables of the configuration,
+	// and reports the variables not given to the template as errors.
+	strict bool
+	// rec records the rendered templates and the calls in them, if set.
+	rec *loadRecorder
+
+	depth int
+}
+
+// resolve resolves the path of a template. dir is the directory of the including template, or empty.
+func (t *templateRenderer) resolve(dir string, p string) (string, error) {
+	if t.fsys == nil {
+		r := t.resolver
+		if dir != "" {
+			r = &fileResolver{
+				basePaths:    append([]string{dir}, t.resolver.basePaths...),
+				sandbox:      t.resolver.sandbox,
+				allowedRoots: t.resolver.allowedRoots,
+			}
 		}
+		return r.resolve(p)
+	}
+	candidates := []string{path.Clean(p)}
+	if dir != "" {
+		candidates = append([]string{path.Join(dir, p)}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if _, err := fs.Stat(t.fsys, candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found", p)
+}
+
+func (t *templateRenderer) read(p string) ([]byte, error) {
+	if t.fsys == nil {
+		return os.ReadFile(p)
+	}
+	return fs.ReadFile(t.fsys, p)
+}
+
+func (t *templateRenderer) dir(p string) string {
+	if t.fsys == nil {
+		return filepath.Dir(p)
+	}
+	return path.Dir(p)
+}
+
+func (t *templateRenderer) renderArgs(dir string, args []cty.Value, inherited map[string]cty.Value) (cty.Value, error) {
+	pathArg, pathMarks := args[0].Unmark()
+	vars := make(map[string]cty.Value, len(inherited))
+	for name, value := range inherited {
+		vars[name] = value
+	}
+	if len(args) > 1 && !args[1].IsNull() {
 		if ty := args[1].Type(); !ty.IsObjectType() && !ty.IsMapType() {
-			return cty.UnknownVal(cty.DynamicPseudoType), errors.New("require second argument is map or object type")
+			return cty.UnknownVal(cty.DynamicPseudoType), function.NewArgErrorf(1, "require second argument is map or object type")
 		}
-		pathArg, pathMarks := args[0].Unmark()
-		targetFile := pathArg.AsString()
-		src, err := openFile(targetFile, basePaths...)
-		if err != nil {
-			err = function.NewArgError(0, err)
-			return cty.UnknownVal(cty.DynamicPseudoType), err
+		for name, value := range args[1].AsValueMap() {
+			vars[name] = value
 		}
-		expr, diags := hclsyntax.ParseTemplate(src, targetFile, hcl.InitialPos)
-		if diags.HasErrors() {
+	}
+	path, err := t.resolve(dir, pathArg.AsString())
+	if err != nil {
+		return cty.UnknownVal(cty.DynamicPseudoType), function.NewArgError(0, err)
+	}
+	value, err := t.render(path, vars)
+	if err != nil {
+		return cty.UnknownVal(cty.DynamicPseudoType), err
+	}
+	return value.WithMarks(pathMarks), nil
+}
+
+func (t *templateRenderer) render(path string, vars map[string]cty.Value) (cty.Value, error) {
+	if t.depth >= t.maxDepth {
+		return cty.UnknownVal(cty.DynamicPseudoType), fmt.Errorf("template nesting depth exceeds the limit of %d while rendering %s, maybe recursive templatefile or template_include", t.maxDepth, path)
+	}
+	t.depth++
+	defer func() {
+		t.depth--
+	}()
+	src, err := t.read(path)
+	if err != nil {
+		return cty.UnknownVal(cty.DynamicPseudoType), err
+	}
+	expr, diags := hclsyntax.ParseTemplate(src, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return cty.UnknownVal(cty.DynamicPseudoType), diags
+	}
+	t.rec.file(path)
+	t.rec.collect(expr)
+	ctx := t.newEvalContext()
+	if t.strict {
+		if diags := checkTemplateVariables(expr, path, vars); diags.HasErrors() {
 			return cty.UnknownVal(cty.DynamicPseudoType), diags
 		}
-		ctx := newEvalContext()
-		ctx = ctx.NewChild()
-		ctx.Variables = args[1].AsValueMap()
-		value, diags := expr.Value(ctx)
-		if diags.HasErrors() {
-			return cty.UnknownVal(cty.DynamicPseudoType), diags
+		ctx = &hcl.EvalContext{
+			Functions: ctx.Functions,
 		}
-		return value.WithMarks(pathMarks), nil
 	}
+	ctx = ctx.NewChild()
+	ctx.Variables = vars
+	ctx.Functions = map[string]function.Function{
+		"template_include": t.rec.recordCalls("template_include", t.makeIncludeFunc(t.dir(path), vars)),
+	}
+	value, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		return cty.UnknownVal(cty.DynamicPseudoType), diags
+	}
+	return value, nil
+}
 
+// makeIncludeFunc returns the template_include function available in a template.
+// It renders a partial template with the variables of the including template, and optional additional variables.
+// The path is resolved relative to the directory of the including template first.
+func (t *templateRenderer) makeIncludeFunc(dir string, vars map[string]cty.Value) function.Function {
 	return function.New(&function.Spec{
+		Description: "Renders a partial template with the variables of the including template.",
 		Params: []function.Parameter{
 			{
 				Name:        "path",
+				Description: "The path of the partial template, relative to the including template.",
 				Type:        cty.String,
 				AllowMarked: true,
 			},
+		},
+		VarParam: &function.Parameter{
+			Name:        "variables",
+			Description: "An optional object of additional variables.",
+			Type:        cty.DynamicPseudoType,
+			AllowNull:   true,
+		},
+		Type: function.StaticReturnType(cty.DynamicPseudoType),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			if len(args) > 2 {
+				return cty.UnknownVal(cty.DynamicPseudoType), errors.New("template_include takes a path and optional variables")
+			}
+			return t.renderArgs(dir, args, vars)
+		},
+	})
+}
+
+func checkTemplateVariables(expr hclsyntax.Expression, path string, vars map[string]cty.Value) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	for _, traversal := range expr.Variables() {
+		name := traversal.RootName()
+		if _, ok := vars[name]; ok {
+			continue
+		}
+		diags = append(diags, NewDiagnosticError(
+			"Missing template variable",
+			fmt.Sprintf("The template %s refers to the variable %q, but it is not passed to the template.", path, name),
+			traversal.SourceRange().Ptr(),
+		))
+	}
+	return diags
+}
+
+const (
+	// DefaultExecTimeout is the timeout of the exec function when ExecOptions.Timeout is not specified.
+	DefaultExecTimeout = 10 * time.Second
+	// DefaultExecMaxOutputSize is the output size limit of the exec function when ExecOptions.MaxOutputSize is not specified.
+	DefaultExecMaxOutputSize = 1024 * 1024
+)
+
+// ExecOptions is the settings for the exec function.
+type ExecOptions struct {
+	// AllowCommands is the allowlist of executables. Each entry is a command name looked up in PATH, or a path to the executable.
+	AllowCommands []string
+	// Timeout is the maximum execution time of a command. If zero, DefaultExecTimeout is used.
+	Timeout time.Duration
+	// Env is the names of environment variables passed to the command. Other environment variables are not passed.
+	Env []string
+	// MaxOutputSize is the maximum size of the command output in bytes. If zero, DefaultExecMaxOutputSize is used.
+	MaxOutputSize int
+}
+
+// limitedBuffer keeps written bytes up to the limit and discards the rest.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remain := b.limit - b.buf.Len(); len(p) > remain {
+		b.exceeded = true
+		b.buf.Write(p[:remain])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+func (opts ExecOptions) lookPath(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+	for _, allow := range opts.AllowCommands {
+		allowPath, err := exec.LookPath(allow)
+		if err != nil {
+			continue
+		}
+		if allowPath == path {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("command `%s` is not allowed", name)
+}
+
+func (opts ExecOptions) environ(lookupEnv func(string) (string, bool)) []string {
+	env := make([]string, 0, len(opts.Env))
+	for _, key := range opts.Env {
+		if value, ok := lookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
+	}
+	return env
+}
+
+// MakeExecFunc returns the exec function, that runs an allowed command and returns its standard output.
+func MakeExecFunc(opts ExecOptions) function.Function {
+	return makeExecFunc(opts, os.LookupEnv)
+}
+
+func makeExecFunc(opts ExecOptions, lookupEnv func(string) (string, bool)) function.Function {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+	maxOutputSize := opts.MaxOutputSize
+	if maxOutputSize <= 0 {
+		maxOutputSize = DefaultExecMaxOutputSize
+	}
+	return function.New(&function.Spec{
+		Description: "Runs an allowed command, and returns its standard output.",
+		Params: []function.Parameter{
 			{
-				Name: "variables",
-				Type: cty.DynamicPseudoType,
+				Name:        "cmd",
+				Description: "The name of the command.",
+				Type:        cty.String,
+				AllowMarked: true,
 			},
 		},
-		Type: func(args []cty.Value) (cty.Type, error) {
-			if !args[0].IsKnown() || args[1].IsKnown() {
-				return cty.DynamicPseudoType, nil
+		VarParam: &function.Parameter{
+			Name:        "args",
+			Description: "The arguments of the command.",
+			Type:        cty.String,
+			AllowMarked: true,
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			var marks []cty.ValueMarks
+			strs := make([]string, len(args))
+			for i, arg := range args {
+				arg, argMarks := arg.Unmark()
+				marks = append(marks, argMarks)
+				strs[i] = arg.AsString()
+			}
+			name := strs[0]
+			path, err := opts.lookPath(name)
+			if err != nil {
+				return cty.UnknownVal(cty.String), function.NewArgError(0, err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			cmd := exec.CommandContext(ctx, path, strs[1:]...)
+			cmd.Env = opts.environ(lookupEnv)
+			stdout := &limitedBuffer{limit: maxOutputSize}
+			stderr := &limitedBuffer{limit: maxOutputSize}
+			err = runCommand(ctx, cmd, stdout, stderr)
+			if ctx.Err() == context.DeadlineExceeded {
+				return cty.UnknownVal(cty.String), fmt.Errorf("command `%s` timed out after %s", name, timeout)
 			}
-			val, err := render(args)
-			return val.Type(), err
+			if err != nil {
+				if msg := strings.TrimSpace(stderr.String()); msg != "" {
+					return cty.UnknownVal(cty.String), fmt.Errorf("command `%s` failed: %w: %s", name, err, msg)
+				}
+				return cty.UnknownVal(cty.String), fmt.Errorf("command `%s` failed: %w", name, err)
+			}
+			if stdout.exceeded {
+				return cty.UnknownVal(cty.String), fmt.Errorf("output of command `%s` exceeds %d bytes", name, maxOutputSize)
+			}
+			return cty.StringVal(stdout.String()).WithMarks(marks...), nil
 		},
+	})
+}
+
+// memoizeExecFunc wraps the exec function to return the result of the first run for the same command and arguments.
+// The configuration is evaluated more than once while loading, so the commands would run for each evaluation otherwise.
+func memoizeExecFunc(f function.Function) function.Function {
+	type execResult struct {
+		value cty.Value
+		err   error
+	}
+	results := make(map[string]execResult)
+	return function.New(&function.Spec{
+		Description: f.Description(),
+		Params:      f.Params(),
+		VarParam:    f.VarParam(),
+		Type:        f.ReturnTypeForValues,
 		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
-			return render(args)
+			var marks []cty.ValueMarks
+			unmarked := make([]cty.Value, len(args))
+			strs := make([]string, len(args))
+			for i, arg := range args {
+				arg, argMarks := arg.Unmark()
+				marks = append(marks, argMarks)
+				unmarked[i] = arg
+				strs[i] = arg.AsString()
+			}
+			key := strings.Join(strs, "\x00")
+			result, ok := results[key]
+			if !ok {
+				result.value, result.err = f.Call(unmarked)
+				results[key] = result
+			}
+			if result.err != nil {
+				return cty.UnknownVal(cty.String), result.err
+			}
+			return result.value.WithMarks(marks...), nil
 		},
 	})
 }
 
+// runCommand runs the command with the output copied from its own pipes.
+// exec.Cmd waits for the copies even after the context is done, so a grandchild process
+// holding the pipes would block it beyond the timeout.
+func runCommand(ctx context.Context, cmd *exec.Cmd, stdout, stderr io.Writer) error {
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer stdoutR.Close()
+	defer stdoutW.Close()
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer stderrR.Close()
+	defer stderrW.Close()
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// the write ends are held by the command only, so the copies finish when it and its descendants exit.
+	stdoutW.Close()
+	stderrW.Close()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(stdout, stdoutR)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(stderr, stderrR)
+	}()
+	copied := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(copied)
+	}()
+	err = cmd.Wait()
+	select {
+	case <-copied:
+	case <-ctx.Done():
+		stdoutR.Close()
+		stderrR.Close()
+		<-copied
+	}
+	return err
+}
+
+// localLocation returns time.Local when the time functions are called, so that the changes of time.Local are followed.
+func localLocation() *time.Location {
+	return time.Local
+}
+
+func utcLocation() *time.Location {
+	return time.UTC
+}
+
 func StrftimeInZone(layout string, zone string, t time.Time) (string, error) {
 	loc, err := time.LoadLocation(zone)
 	if err != nil {
@@ -276,26 +821,53 @@ func Strftime(layout string, loc *time.Location, t time.Time) (string, error) {
 	return t.Format(layout), nil
 }
 
-func nowUnixSeconds() float64 {
-	now := flextime.Now()
-	return float64(now.UnixNano()) / float64(time.Second)
-}
 func unixSecondsToTime(unixSeconds float64) time.Time {
 	return time.Unix(0, int64(unixSeconds*float64(time.Second)))
 }
 
-var NowFunc = function.New(&function.Spec{
-	Params: []function.Parameter{},
-	Type:   function.StaticReturnType(cty.Number),
-	Impl: func(_ []cty.Value, retType cty.Type) (cty.Value, error) {
-		return cty.NumberFloatVal(nowUnixSeconds()), nil
-	},
-})
+func timeToUnixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// argToTime returns the time of a unix seconds argument. null means the current time.
+func argToTime(arg cty.Value, now func() time.Time) time.Time {
+	if arg.IsNull() {
+		return now()
+	}
+	f := arg.AsBigFloat()
+	unixSeconds, _ := f.Float64()
+	return unixSecondsToTime(unixSeconds)
+}
+
+// TimeParse parses a formatted string and returns the time value it represents.
+// layout is a Go time layout, or `rfc3339`. If value does not contain a time zone, it is interpreted in loc.
+func TimeParse(layout string, value string, loc *time.Location) (time.Time, error) {
+	if strings.EqualFold("rfc3339", layout) {
+		layout = time.RFC3339
+	}
+	return time.ParseInLocation(layout, value, loc)
+}
+
+var NowFunc = MakeNowFunc(flextime.Now)
+
+// MakeNowFunc returns the now function, that uses now as the current time.
+func MakeNowFunc(now func() time.Time) function.Function {
+	return function.New(&function.Spec{
+		Description: "Returns the current time as unix seconds.",
+		Params:      []function.Parameter{},
+		Type:        function.StaticReturnType(cty.Number),
+		Impl: func(_ []cty.Value, retType cty.Type) (cty.Value, error) {
+			return cty.NumberFloatVal(timeToUnixSeconds(now())), nil
+		},
+	})
+}
 
 var DurationFunc = function.New(&function.Spec{
+	Description: "Parses a duration string such as \"1h30m\", and returns it as seconds.",
 	Params: []function.Parameter{
 		{
 			Name:        "d",
+			Description: "The duration string.",
 			Type:        cty.String,
 			AllowMarked: true,
 		},
@@ -312,82 +884,360 @@ var DurationFunc = function.New(&function.Spec{
 	},
 })
 
-var StrftimeFunc = function.New(&function.Spec{
-	Params: []function.Parameter{
-		{
-			Name:        "layout",
-			Type:        cty.String,
-			AllowMarked: true,
+var StrftimeFunc = MakeStrftimeFunc(flextime.Now)
+
+// MakeStrftimeFunc returns the strftime function, that uses now as the current time.
+func MakeStrftimeFunc(now func() time.Time) function.Function {
+	return makeStrftimeFunc(now, localLocation)
+}
+
+func makeStrftimeFunc(now func() time.Time, loc func() *time.Location) function.Function {
+	return function.New(&function.Spec{
+		Description: "Formats a time with a strftime layout.",
+		Params: []function.Parameter{
+			{
+				Name:        "layout",
+				Description: "The strftime layout.",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+			{
+				Name:        "unixSeconds",
+				Description: "The time as unix seconds, or null for the current time.",
+				Type:        cty.Number,
+				AllowMarked: true,
+				AllowNull:   true,
+			},
 		},
-		{
-			Name:        "unixSeconds",
-			Type:        cty.Number,
-			AllowMarked: true,
-			AllowNull:   true,
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			layoutArg, layoutMarks := args[0].Unmark()
+			layout := layoutArg.AsString()
+
+			unixSecondsArg, unixSeconcsMarks := args[1].Unmark()
+			t, err := Strftime(layout, loc(), argToTime(unixSecondsArg, now))
+			if err != nil {
+				return cty.UnknownVal(cty.String), err
+			}
+			return cty.StringVal(t).WithMarks(layoutMarks, unixSeconcsMarks), nil
 		},
-	},
-	Type: function.StaticReturnType(cty.String),
-	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
-		layoutArg, layoutMarks := args[0].Unmark()
-		layout := layoutArg.AsString()
-
-		unixSecondsArg, unixSeconcsMarks := args[1].Unmark()
-		var unixSeconds float64
-		if unixSecondsArg.IsNull() {
-			unixSeconds = nowUnixSeconds()
-		} else {
-			f := unixSecondsArg.AsBigFloat()
-			unixSeconds, _ = f.Float64()
-		}
+	})
+}
 
-		t, err := Strftime(layout, time.Local, unixSecondsToTime(unixSeconds))
-		if err != nil {
-			return cty.UnknownVal(cty.String), err
-		}
-		return cty.StringVal(t).WithMarks(layoutMarks, unixSeconcsMarks), nil
-	},
-})
+var StrftimeInZoneFunc = MakeStrftimeInZoneFunc(flextime.Now)
 
-var StrftimeInZoneFunc = function.New(&function.Spec{
+// MakeStrftimeInZoneFunc returns the strftime_in_zone function, that uses now as the current time.
+func MakeStrftimeInZoneFunc(now func() time.Time) function.Function {
+	return function.New(&function.Spec{
+		Description: "Formats a time in the time zone with a strftime layout.",
+		Params: []function.Parameter{
+			{
+				Name:        "layout",
+				Description: "The strftime layout.",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+			{
+				Name:        "timeZone",
+				Description: "The name of the time zone, such as \"Asia/Tokyo\".",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+			{
+				Name:        "unixSeconds",
+				Description: "The time as unix seconds, or null for the current time.",
+				Type:        cty.Number,
+				AllowMarked: true,
+				AllowNull:   true,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			layoutArg, layoutMarks := args[0].Unmark()
+			layout := layoutArg.AsString()
+
+			zoneArg, zoneMarks := args[1].Unmark()
+			zone := zoneArg.AsString()
+
+			unixSecondsArg, unixSeconcsMarks := args[2].Unmark()
+			t, err := StrftimeInZone(layout, zone, argToTime(unixSecondsArg, now))
+			if err != nil {
+				return cty.UnknownVal(cty.String), err
+			}
+			return cty.StringVal(t).WithMarks(layoutMarks, zoneMarks, unixSeconcsMarks), nil
+		},
+	})
+}
+
+var TimeParseFunc = makeTimeParseFunc(localLocation)
+
+// makeTimeParseFunc returns the timeparse function, that interprets the time without a time zone in the location returned by loc.
+func makeTimeParseFunc(loc func() *time.Location) function.Function {
+	return function.New(&function.Spec{
+		Description: "Parses a time string, and returns it as unix seconds.",
+		Params: []function.Parameter{
+			{
+				Name:        "layout",
+				Description: "A Go time layout, or \"rfc3339\".",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+			{
+				Name:        "value",
+				Description: "The time string.",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+		},
+		Type: function.StaticReturnType(cty.Number),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			layoutArg, layoutMarks := args[0].Unmark()
+			valueArg, valueMarks := args[1].Unmark()
+			t, err := TimeParse(layoutArg.AsString(), valueArg.AsString(), loc())
+			if err != nil {
+				return cty.UnknownVal(cty.Number), function.NewArgError(1, err)
+			}
+			return cty.NumberFloatVal(timeToUnixSeconds(t)).WithMarks(layoutMarks, valueMarks), nil
+		},
+	})
+}
+
+var TimeParseInZoneFunc = function.New(&function.Spec{
+	Description: "Parses a time string in the time zone, and returns it as unix seconds.",
 	Params: []function.Parameter{
 		{
 			Name:        "layout",
+			Description: "A Go time layout, or \"rfc3339\".",
 			Type:        cty.String,
 			AllowMarked: true,
 		},
 		{
 			Name:        "timeZone",
+			Description: "The name of the time zone, such as \"Asia/Tokyo\".",
 			Type:        cty.String,
 			AllowMarked: true,
 		},
 		{
-			Name:        "unixSeconds",
-			Type:        cty.Number,
+			Name:        "value",
+			Description: "The time string.",
+			Type:        cty.String,
 			AllowMarked: true,
-			AllowNull:   true,
 		},
 	},
-	Type: function.StaticReturnType(cty.String),
+	Type: function.StaticReturnType(cty.Number),
 	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
 		layoutArg, layoutMarks := args[0].Unmark()
-		layout := layoutArg.AsString()
-
 		zoneArg, zoneMarks := args[1].Unmark()
-		zone := zoneArg.AsString()
-
-		unixSecondsArg, unixSeconcsMarks := args[2].Unmark()
-		var unixSeconds float64
-		if unixSecondsArg.IsNull() {
-			unixSeconds = nowUnixSeconds()
-		} else {
-			f := unixSecondsArg.AsBigFloat()
-			unixSeconds, _ = f.Float64()
+		valueArg, valueMarks := args[2].Unmark()
+		loc, err := time.LoadLocation(zoneArg.AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.Number), function.NewArgError(1, err)
 		}
-
-		t, err := StrftimeInZone(layout, zone, unixSecondsToTime(unixSeconds))
+		t, err := TimeParse(layoutArg.AsString(), valueArg.AsString(), loc)
 		if err != nil {
-			return cty.UnknownVal(cty.String), err
+			return cty.UnknownVal(cty.Number), function.NewArgError(2, err)
 		}
-		return cty.StringVal(t).WithMarks(layoutMarks, zoneMarks, unixSeconcsMarks), nil
+		return cty.NumberFloatVal(timeToUnixSeconds(t)).WithMarks(layoutMarks, zoneMarks, valueMarks), nil
 	},
 })
+
+var TimeCmpFunc = MakeTimeCmpFunc(flextime.Now)
+
+// MakeTimeCmpFunc returns the timecmp function, that uses now as the current time.
+func MakeTimeCmpFunc(now func() time.Time) function.Function {
+	return function.New(&function.Spec{
+		Description: "Compares two times, and returns -1, 0 or 1.",
+		Params: []function.Parameter{
+			{
+				Name:        "unixSecondsA",
+				Description: "The first time as unix seconds, or null for the current time.",
+				Type:        cty.Number,
+				AllowMarked: true,
+				AllowNull:   true,
+			},
+			{
+				Name:        "unixSecondsB",
+				Description: "The second time as unix seconds, or null for the current time.",
+				Type:        cty.Number,
+				AllowMarked: true,
+				AllowNull:   true,
+			},
+		},
+		Type: function.StaticReturnType(cty.Number),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			aArg, aMarks := args[0].Unmark()
+			bArg, bMarks := args[1].Unmark()
+			a := argToTime(aArg, now)
+			b := argToTime(bArg, now)
+			var result int64
+			switch {
+			case a.Before(b):
+				result = -1
+			case a.After(b):
+				result = 1
+			}
+			return cty.NumberIntVal(result).WithMarks(aMarks, bMarks), nil
+		},
+	})
+}
+
+var TimeTruncFunc = MakeTimeTruncFunc(flextime.Now)
+
+// MakeTimeTruncFunc returns the timetrunc function, that uses now as the current time.
+func MakeTimeTruncFunc(now func() time.Time) function.Function {
+	return function.New(&function.Spec{
+		Description: "Truncates a time to a multiple of the duration.",
+		Params: []function.Parameter{
+			{
+				Name:        "unixSeconds",
+				Description: "The time as unix seconds, or null for the current time.",
+				Type:        cty.Number,
+				AllowMarked: true,
+				AllowNull:   true,
+			},
+			{
+				Name:        "d",
+				Description: "The duration string.",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+		},
+		Type: function.StaticReturnType(cty.Number),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			unixSecondsArg, unixSecondsMarks := args[0].Unmark()
+			durationArg, durationMarks := args[1].Unmark()
+			d, err := time.ParseDuration(durationArg.AsString())
+			if err != nil {
+				return cty.UnknownVal(cty.Number), function.NewArgError(1, err)
+			}
+			t := argToTime(unixSecondsArg, now).Truncate(d)
+			return cty.NumberFloatVal(timeToUnixSeconds(t)).WithMarks(unixSecondsMarks, durationMarks), nil
+		},
+	})
+}
+
+var UnixToRFC3339Func = MakeUnixToRFC3339Func(flextime.Now)
+
+// MakeUnixToRFC3339Func returns the unix_to_rfc3339 function, that uses now as the current time.
+func MakeUnixToRFC3339Func(now func() time.Time) function.Function {
+	return makeUnixToRFC3339Func(now, localLocation)
+}
+
+func makeUnixToRFC3339Func(now func() time.Time, loc func() *time.Location) function.Function {
+	return function.New(&function.Spec{
+		Description: "Formats a time as an RFC3339 string.",
+		Params: []function.Parameter{
+			{
+				Name:        "unixSeconds",
+				Description: "The time as unix seconds, or null for the current time.",
+				Type:        cty.Number,
+				AllowMarked: true,
+				AllowNull:   true,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			unixSecondsArg, unixSecondsMarks := args[0].Unmark()
+			t := argToTime(unixSecondsArg, now).In(loc())
+			return cty.StringVal(t.Format(time.RFC3339)).WithMarks(unixSecondsMarks), nil
+		},
+	})
+}
+
+var UnixToRFC3339InZoneFunc = MakeUnixToRFC3339InZoneFunc(flextime.Now)
+
+// MakeUnixToRFC3339InZoneFunc returns the unix_to_rfc3339_in_zone function, that uses now as the current time.
+func MakeUnixToRFC3339InZoneFunc(now func() time.Time) function.Function {
+	return function.New(&function.Spec{
+		Description: "Formats a time in the time zone as an RFC3339 string.",
+		Params: []function.Parameter{
+			{
+				Name:        "timeZone",
+				Description: "The name of the time zone, such as \"Asia/Tokyo\".",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+			{
+				Name:        "unixSeconds",
+				Description: "The time as unix seconds, or null for the current time.",
+				Type:        cty.Number,
+				AllowMarked: true,
+				AllowNull:   true,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			zoneArg, zoneMarks := args[0].Unmark()
+			unixSecondsArg, unixSecondsMarks := args[1].Unmark()
+			loc, err := time.LoadLocation(zoneArg.AsString())
+			if err != nil {
+				return cty.UnknownVal(cty.String), function.NewArgError(0, err)
+			}
+			t := argToTime(unixSecondsArg, now).In(loc)
+			return cty.StringVal(t.Format(time.RFC3339)).WithMarks(zoneMarks, unixSecondsMarks), nil
+		},
+	})
+}
+
+var WeekdayFunc = MakeWeekdayFunc(flextime.Now)
+
+// MakeWeekdayFunc returns the weekday function, that uses now as the current time.
+func MakeWeekdayFunc(now func() time.Time) function.Function {
+	return makeWeekdayFunc(now, localLocation)
+}
+
+func makeWeekdayFunc(now func() time.Time, loc func() *time.Location) function.Function {
+	return function.New(&function.Spec{
+		Description: "Returns the day of the week of a time, such as \"Monday\".",
+		Params: []function.Parameter{
+			{
+				Name:        "unixSeconds",
+				Description: "The time as unix seconds, or null for the current time.",
+				Type:        cty.Number,
+				AllowMarked: true,
+				AllowNull:   true,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			unixSecondsArg, unixSecondsMarks := args[0].Unmark()
+			t := argToTime(unixSecondsArg, now).In(loc())
+			return cty.StringVal(t.Weekday().String()).WithMarks(unixSecondsMarks), nil
+		},
+	})
+}
+
+var WeekdayInZoneFunc = MakeWeekdayInZoneFunc(flextime.Now)
+
+// MakeWeekdayInZoneFunc returns the weekday_in_zone function, that uses now as the current time.
+func MakeWeekdayInZoneFunc(now func() time.Time) function.Function {
+	return function.New(&function.Spec{
+		Description: "Returns the day of the week of a time in the time zone, such as \"Monday\".",
+		Params: []function.Parameter{
+			{
+				Name:        "timeZone",
+				Description: "The name of the time zone, such as \"Asia/Tokyo\".",
+				Type:        cty.String,
+				AllowMarked: true,
+			},
+			{
+				Name:        "unixSeconds",
+				Description: "The time as unix seconds, or null for the current time.",
+				Type:        cty.Number,
+				AllowMarked: true,
+				AllowNull:   true,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			zoneArg, zoneMarks := args[0].Unmark()
+			unixSecondsArg, unixSecondsMarks := args[1].Unmark()
+			loc, err := time.LoadLocation(zoneArg.AsString())
+			if err != nil {
+				return cty.UnknownVal(cty.String), function.NewArgError(0, err)
+			}
+			t := argToTime(unixSecondsArg, now).In(loc)
+			return cty.StringVal(t.Weekday().String()).WithMarks(zoneMarks, unixSecondsMarks), nil
+		},
+	})
+}
@@ -1,6 +1,9 @@
 package hclconfig_test
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -9,6 +12,7 @@ import (
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/mashiike/hclconfig"
 	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func TestFunctions(t *testing.T) {
@@ -51,6 +55,62 @@ func TestFunctions(t *testing.T) {
 			expr: `strftime_in_zone("%Y-%m-%d %H:%M:%S","Asia/Tokyo", now()+duration("48m49s"))`,
 			str:  "2022-11-11 21:00:00",
 		},
+		{
+			expr:   `timeparse("2006-01-02", "2022-11-11")`,
+			number: ptr(float64(now.Truncate(24 * time.Hour).Unix())),
+		},
+		{
+			expr:   `timeparse("rfc3339", "2022-11-11T20:11:11+09:00")`,
+			number: ptr(float64(now.Unix())),
+		},
+		{
+			expr:   `timeparse_in_zone("2006-01-02 15:04:05", "Asia/Tokyo", "2022-11-11 20:11:11")`,
+			number: ptr(float64(now.Unix())),
+		},
+		{
+			expr:   `timeparse_in_zone("rfc3339", "Asia/Tokyo", "2022-11-11T11:11:11Z")`,
+			number: ptr(float64(now.Unix())),
+		},
+		{
+			expr:   `timecmp(now(), now() + 1)`,
+			number: ptr(float64(-1)),
+		},
+		{
+			expr:   `timecmp(now(), null)`,
+			number: ptr(float64(0)),
+		},
+		{
+			expr:   `timecmp(timeparse("2006-01-02", "2022-11-12"), now())`,
+			number: ptr(float64(1)),
+		},
+		{
+			expr: `unix_to_rfc3339(timetrunc(now(), "24h"))`,
+			str:  "2022-11-11T00:00:00Z",
+		},
+		{
+			expr: `unix_to_rfc3339(null)`,
+			str:  "2022-11-11T11:11:11Z",
+		},
+		{
+			expr: `unix_to_rfc3339_in_zone("Asia/Tokyo", now())`,
+			str:  "2022-11-11T20:11:11+09:00",
+		},
+		{
+			expr: `unix_to_rfc3339_in_zone("America/New_York", null)`,
+			str:  "2022-11-11T06:11:11-05:00",
+		},
+		{
+			expr: `weekday(now())`,
+			str:  "Friday",
+		},
+		{
+			expr: `weekday_in_zone("America/Los_Angeles", timetrunc(now(), "24h"))`,
+			str:  "Thursday",
+		},
+		{
+			expr: `format("dt=%s/", strftime("%Y-%m-%d", timetrunc(now() - duration("24h"), "24h")))`,
+			str:  "dt=2022-11-10/",
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.expr, func(t *testing.T) {
@@ -71,4 +131,486 @@ func TestFunctions(t *testing.T) {
 			}
 		})
 	}
+
+	expr, diags := hclsyntax.ParseExpression([]byte(`unix_to_rfc3339_in_zone("Mars/Olympus", 0)`), "expression.hcl", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	_, diags = expr.Value(ctx)
+	require.EqualError(t, diags, `expression.hcl:1,26-38: Invalid function argument; Invalid value for "timeZone" parameter: unknown time zone Mars/Olympus.`)
+}
+
+func TestDecodeExpressionTime(t *testing.T) {
+	ctx := hclconfig.NewEvalContext("testdata")
+	cases := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{
+			expr:     `"30s"`,
+			expected: 30 * time.Second,
+		},
+		{
+			expr:     `duration("1m") * 2`,
+			expected: 2 * time.Minute,
+		},
+		{
+			expr:     `"2022-11-11T11:11:11Z"`,
+			expected: time.Date(2022, 11, 11, 11, 11, 11, 0, time.UTC),
+		},
+		{
+			expr:     `timeparse("rfc3339", "2022-11-11T11:11:11Z")`,
+			expected: time.Date(2022, 11, 11, 11, 11, 11, 0, time.UTC),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			expr, diags := hclsyntax.ParseExpression([]byte(c.expr), "expression.hcl", hcl.InitialPos)
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			switch expected := c.expected.(type) {
+			case time.Duration:
+				var d time.Duration
+				diags = hclconfig.DecodeExpression(expr, ctx, &d)
+				require.False(t, diags.HasErrors(), diags.Error())
+				require.Equal(t, expected, d)
+			case time.Time:
+				var actual time.Time
+				diags = hclconfig.DecodeExpression(expr, ctx, &actual)
+				require.False(t, diags.HasErrors(), diags.Error())
+				require.True(t, expected.Equal(actual), "expected %s, actual %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestExecFunc(t *testing.T) {
+	t.Setenv("HCLCONFIG_EXEC_ALLOWED", "hoge")
+	t.Setenv("HCLCONFIG_EXEC_DENIED", "fuga")
+	loader := hclconfig.New()
+	ctx := loader.NewEvalContext("testdata")
+	expr, diags := hclsyntax.ParseExpression([]byte(`exec("echo", "hoge")`), "expression.hcl", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	_, diags = expr.Value(ctx)
+	require.True(t, diags.HasErrors(), "exec is disabled by default")
+
+	loader.EnableExec(hclconfig.ExecOptions{
+		AllowCommands: []string{"echo", "false", "sleep", "printenv"},
+		Timeout:       500 * time.Millisecond,
+		Env:           []string{"HCLCONFIG_EXEC_ALLOWED"},
+		MaxOutputSize: 16,
+	})
+	ctx = loader.NewEvalContext("testdata")
+	cases := []struct {
+		expr string
+		str  string
+		err  string
+	}{
+		{
+			expr: `trimspace(exec("echo", "hello", "world"))`,
+			str:  "hello world",
+		},
+		{
+			expr: `exec("printenv", "HCLCONFIG_EXEC_ALLOWED")`,
+			str:  "hoge\n",
+		},
+		{
+			expr: `exec("printenv", "HCLCONFIG_EXEC_DENIED")`,
+			err:  `expression.hcl:1,1-6: Error in function call; Call to function "exec" failed: command ` + "`printenv`" + ` failed: exit status 1.`,
+		},
+		{
+			expr: `exec("ls")`,
+			err:  `expression.hcl:1,7-9: Invalid function argument; Invalid value for "cmd" parameter: command ` + "`ls`" + ` is not allowed.`,
+		},
+		{
+			expr: `exec("false")`,
+			err:  `expression.hcl:1,1-6: Error in function call; Call to function "exec" failed: command ` + "`false`" + ` failed: exit status 1.`,
+		},
+		{
+			expr: `exec("sleep", "10")`,
+			err:  `expression.hcl:1,1-6: Error in function call; Call to function "exec" failed: command ` + "`sleep`" + ` timed out after 500ms.`,
+		},
+		{
+			expr: `exec("echo", "0123456789abcdefghijklmn")`,
+			err:  `expression.hcl:1,1-6: Error in function call; Call to function "exec" failed: output of command ` + "`echo`" + ` exceeds 16 bytes.`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			expr, diags := hclsyntax.ParseExpression([]byte(c.expr), "expression.hcl", hcl.InitialPos)
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			value, diags := expr.Value(ctx)
+			if c.err != "" {
+				require.EqualError(t, diags, c.err)
+				return
+			}
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			require.Equal(t, c.str, value.AsString())
+		})
+	}
+}
+
+func TestExecGrandchildHoldingOutput(t *testing.T) {
+	loader := hclconfig.New()
+	loader.EnableExec(hclconfig.ExecOptions{
+		AllowCommands: []string{"sh"},
+		Timeout:       500 * time.Millisecond,
+	})
+	ctx := loader.NewEvalContext("testdata")
+	expr, diags := hclsyntax.ParseExpression([]byte(`exec("sh", "-c", "sleep 10 & echo hoge")`), "expression.hcl", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	start := time.Now()
+	_, diags = expr.Value(ctx)
+	require.EqualError(t, diags, `expression.hcl:1,1-6: Error in function call; Call to function "exec" failed: command `+"`sh`"+` timed out after 500ms.`)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestExecRunsOncePerLoad(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "counter")
+	loader := hclconfig.New()
+	loader.EnableExec(hclconfig.ExecOptions{
+		AllowCommands: []string{"sh"},
+	})
+	var cfg struct {
+		Name  string `hcl:"name"`
+		Upper string `hcl:"upper"`
+	}
+	err := loader.LoadWithBytes(&cfg, "testdata/config.hcl", []byte(`
+locals {
+  name = trimspace(exec("sh", "-c", "echo run >> `+counter+`; echo hoge"))
+}
+name  = local.name
+upper = upper(trimspace(exec("sh", "-c", "echo run >> `+counter+`; echo hoge")))
+`))
+	require.NoError(t, err)
+	require.Equal(t, "hoge", cfg.Name)
+	require.Equal(t, "HOGE", cfg.Upper)
+	bs, err := os.ReadFile(counter)
+	require.NoError(t, err)
+	require.Equal(t, "run\n", string(bs), "the command runs once for the same arguments")
+
+	err = loader.LoadWithBytes(&cfg, "testdata/config.hcl", []byte(`
+name  = trimspace(exec("sh", "-c", "echo run >> `+counter+`; echo fuga"))
+upper = "FUGA"
+`))
+	require.NoError(t, err)
+	require.Equal(t, "fuga", cfg.Name)
+	bs, err = os.ReadFile(counter)
+	require.NoError(t, err)
+	require.Equal(t, "run\nrun\n", string(bs), "the results are not shared between the loads")
+}
+
+func TestSandbox(t *testing.T) {
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0600))
+	allowed := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(allowed, "allowed.txt"), []byte("allowed"), 0600))
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "config.txt"), []byte("config"), 0600))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt")))
+
+	loader := hclconfig.New()
+	loader.Sandbox(allowed)
+	ctx := loader.NewEvalContext(root)
+	ctx.Variables = map[string]cty.Value{
+		"outside": cty.StringVal(outside),
+		"allowed": cty.StringVal(allowed),
+	}
+	cases := []struct {
+		expr string
+		str  string
+		err  string
+	}{
+		{
+			expr: `file("config.txt")`,
+			str:  "config",
+		},
+		{
+			expr: `file("${allowed}/allowed.txt")`,
+			str:  "allowed",
+		},
+		{
+			expr: `file("${outside}/secret.txt")`,
+			err:  "is outside of the allowed roots, reading it is not permitted in sandbox mode",
+		},
+		{
+			expr: `file("../${basename(outside)}/secret.txt")`,
+			err:  "is outside of the allowed roots, reading it is not permitted in sandbox mode",
+		},
+		{
+			expr: `file("link.txt")`,
+			err:  "link.txt is outside of the allowed roots, reading it is not permitted in sandbox mode",
+		},
+		{
+			expr: `file("go.mod")`,
+			err:  "go.mod not found",
+		},
+	}
+	ctx.Functions["basename"] = hclconfig.GoFunc(filepath.Base)
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			expr, diags := hclsyntax.ParseExpression([]byte(c.expr), "expression.hcl", hcl.InitialPos)
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			value, diags := expr.Value(ctx)
+			if c.err != "" {
+				require.True(t, diags.HasErrors())
+				require.Equal(t, "Invalid function argument", diags[0].Summary)
+				require.Contains(t, diags[0].Detail, c.err)
+				return
+			}
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			require.Equal(t, c.str, value.AsString())
+		})
+	}
+}
+
+func TestLoaderEnvironmentAndClock(t *testing.T) {
+	cases := []struct {
+		env      map[string]string
+		now      time.Time
+		expected string
+	}{
+		{
+			env:      map[string]string{"APP_ENV": "prod"},
+			now:      time.Date(2022, 11, 11, 11, 11, 11, 0, time.UTC),
+			expected: "prod-Friday-2022-11-11",
+		},
+		{
+			env:      map[string]string{},
+			now:      time.Date(2022, 11, 12, 11, 11, 11, 0, time.UTC),
+			expected: "dev-Saturday-2022-11-12",
+		},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.expected, func(t *testing.T) {
+			t.Parallel()
+			loader := hclconfig.New()
+			loader.Environment(c.env)
+			loader.Clock(func() time.Time {
+				return c.now
+			})
+			var cfg struct {
+				Value string `hcl:"value"`
+			}
+			err := loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
+value = "${env("APP_ENV", "dev")}-${weekday(null)}-${strftime_in_zone("%Y-%m-%d", "UTC", now())}"
+`))
+			require.NoError(t, err)
+			require.Equal(t, c.expected, cfg.Value)
+		})
+	}
+}
+
+func TestTemplateFile(t *testing.T) {
+	cases := []struct {
+		expr   string
+		strict bool
+		str    string
+		err    string
+	}{
+		{
+			expr: `templatefile("page.tmpl", {title = "hoge", name = "tora"})`,
+			str:  "[HOGE:tora]body of tora",
+		},
+		{
+			expr: `templatefile("recursive.tmpl", {})`,
+			err:  "template nesting depth exceeds the limit of 3 while rendering testdata/templates/recursive.tmpl, maybe recursive templatefile or template_include",
+		},
+		{
+			expr: `templatefile("parent.tmpl", {name = "tora"})`,
+			str:  "tora-prod",
+		},
+		{
+			expr:   `templatefile("parent.tmpl", {name = "tora"})`,
+			strict: true,
+			err:    `testdata/templates/parent.tmpl:1,11-20: Missing template variable; The template testdata/templates/parent.tmpl refers to the variable "local", but it is not passed to the template.`,
+		},
+		{
+			expr:   `templatefile("page.tmpl", {title = "hoge", name = "tora"})`,
+			strict: true,
+			str:    "[HOGE:tora]body of tora",
+		},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s strict=%v", c.expr, c.strict), func(t *testing.T) {
+			loader := hclconfig.New()
+			loader.TemplateDirs("testdata/templates")
+			loader.TemplateMaxDepth(3)
+			loader.StrictTemplates(c.strict)
+			ctx := loader.NewEvalContext("testdata")
+			ctx.Variables["local"] = cty.ObjectVal(map[string]cty.Value{
+				"env": cty.StringVal("prod"),
+			})
+			expr, diags := hclsyntax.ParseExpression([]byte(c.expr), "expression.hcl", hcl.InitialPos)
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			value, diags := expr.Value(ctx)
+			if c.err != "" {
+				require.True(t, diags.HasErrors())
+				require.Contains(t, diags.Error(), c.err)
+				return
+			}
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			require.Equal(t, c.str, value.AsString())
+		})
+	}
+}
+
+func TestPureTimeZone(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("JST", 9*60*60)
+	defer func() {
+		time.Local = local
+	}()
+	cases := []struct {
+		expr  string
+		local string
+		pure  string
+	}{
+		{
+			expr:  `strftime("%Y-%m-%d %H:%M", 0)`,
+			local: "1970-01-01 09:00",
+			pure:  "1970-01-01 00:00",
+		},
+		{
+			expr:  `unix_to_rfc3339(0)`,
+			local: "1970-01-01T09:00:00+09:00",
+			pure:  "1970-01-01T00:00:00Z",
+		},
+		{
+			expr:  `format("%d", timeparse("2006-01-02 15:04", "1970-01-02 09:00"))`,
+			local: "86400",
+			pure:  "118800",
+		},
+		{
+			expr:  `weekday(-3600)`,
+			local: "Thursday",
+			pure:  "Wednesday",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			expr, diags := hclsyntax.ParseExpression([]byte(c.expr), "expression.hcl", hcl.InitialPos)
+			require.False(t, diags.HasErrors())
+			for _, pure := range []bool{false, true} {
+				loader := hclconfig.New()
+				loader.Pure(pure)
+				value, diags := expr.Value(loader.NewEvalContext("testdata"))
+				if diags.HasErrors() {
+					require.FailNow(t, diags.Error())
+				}
+				expected := c.local
+				if pure {
+					expected = c.pure
+				}
+				require.Equal(t, expected, value.AsString(), "pure=%v", pure)
+			}
+		})
+	}
+}
+
+func TestTimeFunctionsFollowLocal(t *testing.T) {
+	local := time.Local
+	defer func() {
+		time.Local = local
+	}()
+	time.Local = time.FixedZone("JST", 9*60*60)
+	value, err := hclconfig.UnixToRFC3339Func.Call([]cty.Value{cty.NumberIntVal(0)})
+	require.NoError(t, err)
+	require.Equal(t, "1970-01-01T09:00:00+09:00", value.AsString())
+	value, err = hclconfig.WeekdayFunc.Call([]cty.Value{cty.NumberIntVal(-3600)})
+	require.NoError(t, err)
+	require.Equal(t, "Thursday", value.AsString())
+	value, err = hclconfig.StrftimeFunc.Call([]cty.Value{cty.StringVal("%H:%M"), cty.NumberIntVal(0)})
+	require.NoError(t, err)
+	require.Equal(t, "09:00", value.AsString())
+	value, err = hclconfig.TimeParseFunc.Call([]cty.Value{cty.StringVal("2006-01-02 15:04"), cty.StringVal("1970-01-02 09:00")})
+	require.NoError(t, err)
+	require.True(t, value.RawEquals(cty.NumberIntVal(86400)))
+
+	time.Local = time.FixedZone("EST", -5*60*60)
+	value, err = hclconfig.UnixToRFC3339Func.Call([]cty.Value{cty.NumberIntVal(0)})
+	require.NoError(t, err)
+	require.Equal(t, "1969-12-31T19:00:00-05:00", value.AsString())
+	value, err = hclconfig.TimeParseFunc.Call([]cty.Value{cty.StringVal("2006-01-02 15:04"), cty.StringVal("1970-01-02 09:00")})
+	require.NoError(t, err)
+	require.True(t, value.RawEquals(cty.NumberIntVal(50400+86400)))
+}
+
+func TestDisableFunctions(t *testing.T) {
+	cases := []struct {
+		expr     string
+		pure     bool
+		disabled []string
+		str      string
+		err      string
+	}{
+		{
+			expr:     `upper("hoge")`,
+			disabled: []string{"lower"},
+			str:      "HOGE",
+		},
+		{
+			expr:     `lower("HOGE")`,
+			disabled: []string{"lower"},
+			err:      `expression.hcl:1,1-7: Error in function call; Call to function "lower" failed: lower is disabled in this loader.`,
+		},
+		{
+			expr: `env("HOME", "none")`,
+			pure: true,
+			err:  `expression.hcl:1,1-5: Error in function call; Call to function "env" failed: env is disabled in pure mode.`,
+		},
+		{
+			expr: `file("hoge.txt")`,
+			pure: true,
+			err:  `expression.hcl:1,1-6: Error in function call; Call to function "file" failed: file is disabled in pure mode.`,
+		},
+		{
+			expr: `strftime("%Y", null)`,
+			pure: true,
+			err:  `expression.hcl:1,16-20: Invalid function argument; Invalid value for "unixSeconds" parameter: the current time is not available in pure mode, the time must be given.`,
+		},
+		{
+			expr: `strftime_in_zone("%Y-%m-%d", "UTC", 1668165071)`,
+			pure: true,
+			str:  "2022-11-11",
+		},
+		{
+			expr: `weekday(timeparse("rfc3339", "2022-11-11T11:11:11Z"))`,
+			pure: true,
+			str:  "Friday",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			loader := hclconfig.New()
+			loader.Pure(c.pure)
+			loader.DisableFunctions(c.disabled...)
+			ctx := loader.NewEvalContext("testdata")
+			expr, diags := hclsyntax.ParseExpression([]byte(c.expr), "expression.hcl", hcl.InitialPos)
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			value, diags := expr.Value(ctx)
+			if c.err != "" {
+				require.EqualError(t, diags, c.err)
+				return
+			}
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			require.Equal(t, c.str, value.AsString())
+		})
+	}
 }
@@ -0,0 +1,163 @@
+package hclconfig
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/gocty"
+)
+
+var (
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+	ctyValueType = reflect.TypeOf(cty.Value{})
+)
+
+// GoFunc converts a plain Go function into a function that can be used during HCL decoding.
+//
+// The parameter and return types are derived from the Go signature with the same rules as gocty:
+// structs are objects of `cty` tagged fields, slices are lists and maps with string keys are maps.
+// cty.Value and interface{} parameters accept any type. Pointer parameters are nullable, and
+// the last parameter of a variadic function becomes the variadic parameter.
+// The function must return a value, or a value and an error. Marks on the arguments are propagated to the result.
+//
+// GoFunc panics if fn is not a function, or if its signature can not be represented with cty types.
+//
+//	hclconfig.Functions(map[string]function.Function{
+//		"repeat": hclconfig.GoFunc(func(s string, n int) (string, error) {
+//			return strings.Repeat(s, n), nil
+//		}),
+//	})
+func GoFunc(fn interface{}) function.Function {
+	f, err := newGoFunc(fn)
+	if err != nil {
+		panic(fmt.Errorf("hclconfig.GoFunc: %w", err))
+	}
+	return f
+}
+
+func newGoFunc(fn interface{}) (function.Function, error) {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func {
+		return function.Function{}, fmt.Errorf("given value must be func, not %T", fn)
+	}
+	ft := rv.Type()
+	switch ft.NumOut() {
+	case 1:
+	case 2:
+		if ft.Out(1) != errorType {
+			return function.Function{}, fmt.Errorf("second return value must be error, not %s", ft.Out(1))
+		}
+	default:
+		return function.Function{}, fmt.Errorf("func must return a value, or a value and an error")
+	}
+	if ft.Out(0).Kind() == reflect.Interface {
+		return function.Function{}, fmt.Errorf("return type %s can not be represented with cty types", ft.Out(0))
+	}
+	retType, err := goTypeToCtyType(ft.Out(0))
+	if err != nil {
+		return function.Function{}, fmt.Errorf("return type: %w", err)
+	}
+
+	numIn := ft.NumIn()
+	if ft.IsVariadic() {
+		numIn--
+	}
+	params := make([]function.Parameter, 0, numIn)
+	goTypes := make([]reflect.Type, 0, ft.NumIn())
+	for i := 0; i < numIn; i++ {
+		param, err := goTypeToParameter(fmt.Sprintf("arg%d", i), ft.In(i))
+		if err != nil {
+			return function.Function{}, fmt.Errorf("parameter %d: %w", i, err)
+		}
+		params = append(params, param)
+		goTypes = append(goTypes, ft.In(i))
+	}
+	var varParam *function.Parameter
+	if ft.IsVariadic() {
+		elemType := ft.In(numIn).Elem()
+		param, err := goTypeToParameter("args", elemType)
+		if err != nil {
+			return function.Function{}, fmt.Errorf("variadic parameter: %w", err)
+		}
+		varParam = &param
+		goTypes = append(goTypes, elemType)
+	}
+
+	return function.New(&function.Spec{
+		Params:   params,
+		VarParam: varParam,
+		Type:     function.StaticReturnType(retType),
+		Impl: func(args []cty.Value, _ cty.Type) (cty.Value, error) {
+			in := make([]reflect.Value, len(args))
+			var marks []cty.ValueMarks
+			for i, arg := range args {
+				arg, argMarks := arg.UnmarkDeep()
+				marks = append(marks, argMarks)
+				goType := goTypes[len(goTypes)-1]
+				if i < len(goTypes) {
+					goType = goTypes[i]
+				}
+				v, err := ctyValueToGoValue(arg, goType)
+				if err != nil {
+					return cty.UnknownVal(retType), function.NewArgError(i, err)
+				}
+				in[i] = v
+			}
+			out := rv.Call(in)
+			if len(out) == 2 && !out[1].IsNil() {
+				return cty.UnknownVal(retType), out[1].Interface().(error)
+			}
+			ret, err := gocty.ToCtyValue(out[0].Interface(), retType)
+			if err != nil {
+				return cty.UnknownVal(retType), err
+			}
+			return ret.WithMarks(marks...), nil
+		},
+	}), nil
+}
+
+func goTypeToParameter(name string, rt reflect.Type) (function.Parameter, error) {
+	ty, err := goTypeToCtyType(rt)
+	if err != nil {
+		return function.Parameter{}, err
+	}
+	return function.Parameter{
+		Name:             name,
+		Type:             ty,
+		AllowNull:        rt.Kind() == reflect.Pointer || ty == cty.DynamicPseudoType,
+		AllowDynamicType: ty == cty.DynamicPseudoType,
+		AllowMarked:      true,
+	}, nil
+}
+
+func goTypeToCtyType(rt reflect.Type) (cty.Type, error) {
+	if rt == ctyValueType {
+		return cty.DynamicPseudoType, nil
+	}
+	if rt.Kind() == reflect.Interface {
+		if rt.NumMethod() != 0 {
+			return cty.NilType, fmt.Errorf("no cty.Type for %s", rt)
+		}
+		return cty.DynamicPseudoType, nil
+	}
+	return gocty.ImpliedType(reflect.New(rt).Interface())
+}
+
+func ctyValueToGoValue(value cty.Value, rt reflect.Type) (reflect.Value, error) {
+	target := reflect.New(rt)
+	switch {
+	case rt == ctyValueType:
+		target.Elem().Set(reflect.ValueOf(value))
+	case rt.Kind() == reflect.Interface:
+		if v := ctyValueToInterface(value); v != nil {
+			target.Elem().Set(reflect.ValueOf(v))
+		}
+	default:
+		if err := gocty.FromCtyValue(value, target.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	return target.Elem(), nil
+}
@@ -0,0 +1,135 @@
+package hclconfig_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mashiike/hclconfig"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+type testEndpoint struct {
+	Host string `cty:"host"`
+	Port int    `cty:"port"`
+}
+
+func TestGoFunc(t *testing.T) {
+	loader := hclconfig.New()
+	loader.Functions(map[string]function.Function{
+		"repeat": hclconfig.GoFunc(func(s string, n int) (string, error) {
+			if n < 0 {
+				return "", errors.New("n must be positive")
+			}
+			return strings.Repeat(s, n), nil
+		}),
+		"sum": hclconfig.GoFunc(func(base int, nums ...int) int {
+			for _, n := range nums {
+				base += n
+			}
+			return base
+		}),
+		"greet": hclconfig.GoFunc(func(name *string) string {
+			if name == nil {
+				return "hello, anonymous"
+			}
+			return "hello, " + *name
+		}),
+		"endpoint": hclconfig.GoFunc(func(e testEndpoint) string {
+			return fmt.Sprintf("%s:%d", e.Host, e.Port)
+		}),
+		"default_endpoint": hclconfig.GoFunc(func() testEndpoint {
+			return testEndpoint{Host: "localhost", Port: 8080}
+		}),
+		"typeof": hclconfig.GoFunc(func(v interface{}) string {
+			return fmt.Sprintf("%T", v)
+		}),
+	})
+	ctx := loader.NewEvalContext("testdata")
+	ctx.Variables = map[string]cty.Value{
+		"secret": cty.StringVal("hoge").Mark("sensitive"),
+	}
+	cases := []struct {
+		expr     string
+		expected cty.Value
+		err      string
+	}{
+		{
+			expr:     `repeat("ab", 3)`,
+			expected: cty.StringVal("ababab"),
+		},
+		{
+			expr: `repeat("ab", -1)`,
+			err:  `expression.hcl:1,1-8: Error in function call; Call to function "repeat" failed: n must be positive.`,
+		},
+		{
+			expr:     `sum(1)`,
+			expected: cty.NumberIntVal(1),
+		},
+		{
+			expr:     `sum(1, 2, 3)`,
+			expected: cty.NumberIntVal(6),
+		},
+		{
+			expr:     `greet(null)`,
+			expected: cty.StringVal("hello, anonymous"),
+		},
+		{
+			expr:     `greet("tora")`,
+			expected: cty.StringVal("hello, tora"),
+		},
+		{
+			expr:     `endpoint({host = "example.com", port = 443})`,
+			expected: cty.StringVal("example.com:443"),
+		},
+		{
+			expr:     `default_endpoint().port`,
+			expected: cty.NumberIntVal(8080),
+		},
+		{
+			expr:     `typeof(1)`,
+			expected: cty.StringVal("int64"),
+		},
+		{
+			expr:     `repeat(secret, 2)`,
+			expected: cty.StringVal("hogehoge").Mark("sensitive"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			expr, diags := hclsyntax.ParseExpression([]byte(c.expr), "expression.hcl", hcl.InitialPos)
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			value, diags := expr.Value(ctx)
+			if c.err != "" {
+				require.EqualError(t, diags, c.err)
+				return
+			}
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			require.True(t, c.expected.RawEquals(value), "expected %#v, actual %#v", c.expected, value)
+		})
+	}
+}
+
+func TestGoFuncInvalidSignature(t *testing.T) {
+	require.Panics(t, func() {
+		hclconfig.GoFunc("not func")
+	})
+	require.Panics(t, func() {
+		hclconfig.GoFunc(func() {})
+	})
+	require.Panics(t, func() {
+		hclconfig.GoFunc(func() (string, string) { return "", "" })
+	})
+	require.Panics(t, func() {
+		hclconfig.GoFunc(func(ch chan int) string { return "" })
+	})
+}
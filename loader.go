@@ -1,16 +1,23 @@
 package hclconfig
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
 
+	"github.com/Songmu/flextime"
 	"github.com/hashicorp/hcl/v2"
-	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/mattn/go-isatty"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 	"github.com/zclconf/go-cty/cty/function"
 	"golang.org/x/term"
 )
@@ -24,8 +31,20 @@ type Loader struct {
 	width       uint
 	color       bool
 
-	variables map[string]cty.Value
-	functions map[string]function.Function
+	variables         map[string]cty.Value
+	functions         map[string]function.Function
+	disabledFunctions map[string]bool
+	pure              bool
+	execOptions       *ExecOptions
+	lookupEnv         func(string) (string, bool)
+	now               func() time.Time
+
+	sandbox      bool
+	allowedRoots []string
+
+	templateDirs     []string
+	templateMaxDepth int
+	strictTemplates  bool
 }
 
 // New creates a Loader instance.
@@ -38,8 +57,11 @@ func New() *Loader {
 		diagsOutput: os.Stderr,
 		width:       uint(width),
 		color:       isatty.IsTerminal(os.Stdout.Fd()),
+		lookupEnv:   os.LookupEnv,
+		now:         flextime.Now,
+
+		templateMaxDepth: DefaultTemplateMaxDepth,
 	}
-	l.Functions(defaultFunctions)
 	return l
 }
 
@@ -50,18 +72,95 @@ func NewEvalContext(paths ...string) *hcl.EvalContext {
 
 // NewEvalContext creates a new evaluation context.
 func (l *Loader) NewEvalContext(paths ...string) *hcl.EvalContext {
+	return l.newEvalContext(nil, paths...)
+}
+
+// newEvalContext creates a new evaluation context, with the functions recording their calls to rec if it is not nil.
+func (l *Loader) newEvalContext(rec *loadRecorder, paths ...string) *hcl.EvalContext {
 	ctx := &hcl.EvalContext{
-		Variables: l.variables,
-		Functions: l.functions,
+		Variables: mergeVariables(make(map[string]cty.Value, len(l.variables)), l.variables),
+	}
+	ctx.Functions = mergeFunctions(l.builtinFunctions(ctx, rec, paths...), l.functions)
+	if l.pure {
+		for name, f := range ctx.Functions {
+			if impureFunctions[name] {
+				ctx.Functions[name] = makeDisabledFunc(name, "pure mode")
+				continue
+			}
+			if params, ok := currentTimeParams[name]; ok {
+				ctx.Functions[name] = withoutCurrentTime(f, params...)
+			}
+		}
+	}
+	for name := range l.disabledFunctions {
+		ctx.Functions[name] = makeDisabledFunc(name, "this loader")
+	}
+	if rec != nil {
+		for name, f := range ctx.Functions {
+			ctx.Functions[name] = rec.recordCalls(name, f)
+		}
 	}
-	ctx.Functions["file"] = MakeFileFunc(paths...)
-	ctx.Functions["templatefile"] = MakeTemplateFileFunc(
-		func() *hcl.EvalContext {
-			return ctx
-		}, paths...)
 	return ctx
 }
 
+// isDisabled reports whether the function is disabled by DisableFunctions or the pure mode.
+func (l *Loader) isDisabled(name string) bool {
+	return l.disabledFunctions[name] || (l.pure && impureFunctions[name])
+}
+
+// builtinFunctions returns the default functions bound to the settings of the Loader.
+func (l *Loader) builtinFunctions(ctx *hcl.EvalContext, rec *loadRecorder, paths ...string) map[string]function.Function {
+	functions := mergeFunctions(make(map[string]function.Function, len(defaultFunctions)+4), defaultFunctions)
+	functions["env"] = makeEnvFunc(l.lookupEnv, rec)
+	functions["must_env"] = makeMustEnvFunc(l.lookupEnv, rec)
+	// the time zone of the machine is not used in the pure mode.
+	loc := localLocation
+	if l.pure {
+		loc = utcLocation
+	}
+	functions["now"] = MakeNowFunc(l.now)
+	functions["strftime"] = makeStrftimeFunc(l.now, loc)
+	functions["strftime_in_zone"] = MakeStrftimeInZoneFunc(l.now)
+	functions["timecmp"] = MakeTimeCmpFunc(l.now)
+	functions["timeparse"] = makeTimeParseFunc(loc)
+	functions["timetrunc"] = MakeTimeTruncFunc(l.now)
+	functions["unix_to_rfc3339"] = makeUnixToRFC3339Func(l.now, loc)
+	functions["unix_to_rfc3339_in_zone"] = MakeUnixToRFC3339InZoneFunc(l.now)
+	functions["weekday"] = makeWeekdayFunc(l.now, loc)
+	functions["weekday_in_zone"] = MakeWeekdayInZoneFunc(l.now)
+
+	resolver := l.fileResolver(paths...)
+	resolver.rec = rec
+	functions["file"] = makeFileFunc(resolver)
+	functions["templatefile"] = makeTemplateFileFunc(&templateRenderer{
+		newEvalContext: func() *hcl.EvalContext {
+			return ctx
+		},
+		resolver: l.templateResolver(paths...),
+		maxDepth: l.templateMaxDepth,
+		strict:   l.strictTemplates,
+		rec:      rec,
+	})
+	if l.execOptions != nil {
+		functions["exec"] = memoizeExecFunc(makeExecFunc(*l.execOptions, l.lookupEnv))
+	}
+	return functions
+}
+
+func (l *Loader) fileResolver(paths ...string) *fileResolver {
+	return &fileResolver{
+		basePaths:    paths,
+		sandbox:      l.sandbox,
+		allowedRoots: l.allowedRoots,
+	}
+}
+
+func (l *Loader) templateResolver(paths ...string) *fileResolver {
+	r := l.fileResolver(paths...)
+	r.basePaths = append(r.basePaths[:len(r.basePaths):len(r.basePaths)], l.templateDirs...)
+	return r
+}
+
 // DiagnosticWriter sets up a Writer to write the diagnostic when an error occurs in the Loader.
 func DiagnosticWriter(w hcl.DiagnosticWriter) {
 	defaultLoader.DiagnosticWriter(w)
@@ -92,6 +191,139 @@ func (l *Loader) Functions(functions map[string]function.Function) {
 	l.functions = mergeFunctions(l.functions, functions)
 }
 
+// EnableExec enables the exec function, that runs a command in the allowlist and returns its standard output.
+// The exec function is disabled by default.
+func EnableExec(opts ExecOptions) {
+	defaultLoader.EnableExec(opts)
+}
+
+// EnableExec enables the exec function, that runs a command in the allowlist and returns its standard output.
+// The exec function is disabled by default.
+// The same command and arguments run once in an evaluation context, even if the configuration is evaluated more than once while loading.
+func (l *Loader) EnableExec(opts ExecOptions) {
+	l.execOptions = &opts
+}
+
+// Sandbox enables the sandbox mode, that confines the files read by the file and templatefile functions
+// to the load paths and the given allowed roots.
+// Absolute paths outside of them, `..` escapes and symlinks pointing outside are rejected.
+func Sandbox(allowedRoots ...string) {
+	defaultLoader.Sandbox(allowedRoots...)
+}
+
+// Sandbox enables the sandbox mode, that confines the files read by the file and templatefile functions
+// to the load paths and the given allowed roots.
+// Absolute paths outside of them, `..` escapes and symlinks pointing outside are rejected.
+func (l *Loader) Sandbox(allowedRoots ...string) {
+	l.sandbox = true
+	l.allowedRoots = append(l.allowedRoots, allowedRoots...)
+}
+
+// TemplateDirs adds directories where templatefile searches templates, after the load paths.
+func TemplateDirs(dirs ...string) {
+	defaultLoader.TemplateDirs(dirs...)
+}
+
+// TemplateDirs adds directories where templatefile searches templates, after the load paths.
+func (l *Loader) TemplateDirs(dirs ...string) {
+	l.templateDirs = append(l.templateDirs, dirs...)
+}
+
+// TemplateMaxDepth sets the limit of the nesting depth of templatefile and template_include. The default is DefaultTemplateMaxDepth.
+func TemplateMaxDepth(depth int) {
+	defaultLoader.TemplateMaxDepth(depth)
+}
+
+// TemplateMaxDepth sets the limit of the nesting depth of templatefile and template_include. The default is DefaultTemplateMaxDepth.
+func (l *Loader) TemplateMaxDepth(depth int) {
+	l.templateMaxDepth = depth
+}
+
+// StrictTemplates enables the strict template mode.
+// In this mode, templates can only refer to the variables given to templatefile,
+// and references to missing variables are reported as errors pointing into the template file.
+func StrictTemplates(strict bool) {
+	defaultLoader.StrictTemplates(strict)
+}
+
+// StrictTemplates enables the strict template mode.
+// In this mode, templates can only refer to the variables given to templatefile,
+// and references to missing variables are reported as errors pointing into the template file.
+func (l *Loader) StrictTemplates(strict bool) {
+	l.strictTemplates = strict
+}
+
+// DisableFunctions disables the functions with the given names.
+func DisableFunctions(names ...string) {
+	defaultLoader.DisableFunctions(names...)
+}
+
+// DisableFunctions disables the functions with the given names.
+// A call to a disabled function is reported as an error, even if the function is added with Functions.
+func (l *Loader) DisableFunctions(names ...string) {
+	if l.disabledFunctions == nil {
+		l.disabledFunctions = make(map[string]bool, len(names))
+	}
+	for _, name := range names {
+		l.disabledFunctions[name] = true
+	}
+}
+
+// Pure enables the pure mode, that excludes the functions depending on the environment.
+func Pure(pure bool) {
+	defaultLoader.Pure(pure)
+}
+
+// Pure enables the pure mode, that excludes the functions depending on the environment.
+// In this mode, env, must_env, file, templatefile, now and exec are disabled,
+// the time functions such as strftime require the time argument instead of using the current time,
+// and strftime, timeparse, unix_to_rfc3339 and weekday use UTC instead of the local time zone.
+// The same configuration always produces the same result in this mode.
+func (l *Loader) Pure(pure bool) {
+	l.pure = pure
+}
+
+// EnvironmentLookup sets the function used to read environment variables, instead of os.LookupEnv.
+// It is used by the env, must_env and exec functions.
+func EnvironmentLookup(lookupEnv func(string) (string, bool)) {
+	defaultLoader.EnvironmentLookup(lookupEnv)
+}
+
+// EnvironmentLookup sets the function used to read environment variables, instead of os.LookupEnv.
+// It is used by the env, must_env and exec functions.
+func (l *Loader) EnvironmentLookup(lookupEnv func(string) (string, bool)) {
+	l.lookupEnv = lookupEnv
+}
+
+// Environment sets the environment variables used instead of the process environment.
+func Environment(env map[string]string) {
+	defaultLoader.Environment(env)
+}
+
+// Environment sets the environment variables used instead of the process environment.
+func (l *Loader) Environment(env map[string]string) {
+	copied := make(map[string]string, len(env))
+	for key, value := range env {
+		copied[key] = value
+	}
+	l.EnvironmentLookup(func(key string) (string, bool) {
+		value, ok := copied[key]
+		return value, ok
+	})
+}
+
+// Clock sets the function that returns the current time, instead of flextime.Now.
+// It is used by now and the other time functions.
+func Clock(now func() time.Time) {
+	defaultLoader.Clock(now)
+}
+
+// Clock sets the function that returns the current time, instead of flextime.Now.
+// It is used by now and the other time functions.
+func (l *Loader) Clock(now func() time.Time) {
+	l.now = now
+}
+
 // Variables adds variables used during HCL decoding.
 func Variables(variables map[string]cty.Value) {
 	defaultLoader.Variables(variables)
@@ -136,16 +368,50 @@ func DecodeBody(body hcl.Body, ctx *hcl.EvalContext, cfg interface{}) hcl.Diagno
 	if decoder, ok := cfg.(BodyDecoder); ok {
 		return decoder.DecodeBody(body, ctx)
 	}
-	diags := gohcl.DecodeBody(body, ctx, cfg)
+	rv := reflect.ValueOf(cfg)
+	if rv.Kind() != reflect.Pointer {
+		panic(fmt.Sprintf("target value must be a pointer, not %s", rv.Type().String()))
+	}
+	if rv.Elem().Kind() == reflect.Slice {
+		return decodeBodyToSliceElem(body, ctx, rv.Elem())
+	}
+	diags := decodeBodyToValue(body, ctx, rv.Elem())
+	if diags.HasErrors() {
+		return diags
+	}
+	diags = append(diags, resolveRefs(rv.Elem())...)
 	if diags.HasErrors() {
 		return diags
 	}
 
 	restrictDiags := restrict(body, ctx, cfg)
 	diags = append(diags, restrictDiags...)
+	if diags.HasErrors() {
+		return diags
+	}
+	diags = append(diags, runValidators(body, ctx, cfg)...)
 	return diags
 }
 
+// decodeBodyToSliceElem decodes the body into a new element, and appends it to the slice.
+func decodeBodyToSliceElem(body hcl.Body, ctx *hcl.EvalContext, val reflect.Value) hcl.Diagnostics {
+	elem := reflect.New(val.Type().Elem())
+	diags := DecodeBody(body, ctx, elem.Interface())
+	if diags.HasErrors() {
+		return diags
+	}
+	val.Set(reflect.Append(val, elem.Elem()))
+	return diags
+}
+
+func isSliceTarget(cfg interface{}) bool {
+	if _, ok := cfg.(BodyDecoder); ok {
+		return false
+	}
+	rv := reflect.ValueOf(cfg)
+	return rv.Kind() == reflect.Pointer && rv.Elem().Kind() == reflect.Slice
+}
+
 func (l *Loader) writeDiags(diags hcl.Diagnostics, files map[string]*hcl.File) error {
 	if len(diags) > 0 {
 		w := l.diagsWriter
@@ -168,7 +434,30 @@ func Load(cfg interface{}, paths ...string) error {
 
 // Load considers `paths` as a configuration file county written in HCL and reads *.hcl and *.hcl.json.
 // and assigns the decoded values to the `cfg` values.
+// If `cfg` is a pointer to a slice, each file is loaded into its own element in the order of the file names.
 func (l *Loader) Load(cfg interface{}, paths ...string) error {
+	_, err := l.LoadWithResult(cfg, paths...)
+	return err
+}
+
+// LoadResult is the result of a load.
+type LoadResult struct {
+	// Report is the record of what the load depended on.
+	Report *LoadReport
+	// EvalContext has the functions, the locals and the implied variables of the loaded configuration.
+	EvalContext *hcl.EvalContext
+
+	newTemplateRenderer func(fsys fs.FS) *templateRenderer
+}
+
+// LoadWithResult is the same as Load, and returns the LoadResult.
+func LoadWithResult(cfg interface{}, paths ...string) (*LoadResult, error) {
+	return defaultLoader.LoadWithResult(cfg, paths...)
+}
+
+// LoadWithResult is the same as Load, and returns the LoadResult.
+// The result is nil if the configuration files can not be parsed.
+func (l *Loader) LoadWithResult(cfg interface{}, paths ...string) (*LoadResult, error) {
 	parser := hclparse.NewParser()
 	var diags hcl.Diagnostics
 	for _, path := range paths {
@@ -176,16 +465,47 @@ func (l *Loader) Load(cfg interface{}, paths ...string) error {
 	}
 	files := parser.Files()
 	if diags.HasErrors() {
-		return l.writeDiags(diags, files)
+		return nil, l.writeDiags(diags, files)
 	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 	parsed := make([]*hcl.File, 0, len(files))
-	for _, f := range files {
-		parsed = append(parsed, f)
+	rec := newLoadRecorder()
+	for _, name := range names {
+		parsed = append(parsed, files[name])
+		if body, ok := files[name].Body.(*hclsyntax.Body); ok {
+			rec.collect(body)
+		}
+	}
+	var ctx *hcl.EvalContext
+	if isSliceTarget(cfg) {
+		// the files are loaded with their own locals and implied variables.
+		ctx = l.newEvalContext(nil, paths...)
+		for _, f := range parsed {
+			diags = append(diags, l.LoadWithBody(cfg, l.newEvalContext(rec, paths...), f.Body)...)
+		}
+	} else {
+		ctx = l.newEvalContext(rec, paths...)
+		diags = append(diags, l.LoadWithBody(cfg, ctx, hcl.MergeFiles(parsed))...)
+	}
+	return l.newLoadResult(rec, ctx, paths...), l.writeDiags(diags, files)
+}
+
+// newLoadResult creates the LoadResult with the variables of ctx. The functions are created again,
+// so that the calls after the load are not recorded.
+func (l *Loader) newLoadResult(rec *loadRecorder, ctx *hcl.EvalContext, paths ...string) *LoadResult {
+	evalContext := l.newEvalContext(nil, paths...)
+	evalContext.Variables = mergeVariables(evalContext.Variables, ctx.Variables)
+	return &LoadResult{
+		Report:      rec.finish(),
+		EvalContext: evalContext,
+		newTemplateRenderer: func(fsys fs.FS) *templateRenderer {
+			return l.newTemplateRenderer(evalContext, fsys, paths...)
+		},
 	}
-	body := hcl.MergeFiles(parsed)
-	ctx := l.NewEvalContext(paths...)
-	diags = append(diags, l.LoadWithBody(cfg, ctx, body)...)
-	return l.writeDiags(diags, files)
 }
 
 func (l *Loader) parse(parser *hclparse.Parser, path string) hcl.Diagnostics {
@@ -220,6 +540,18 @@ func LoadWithBytes(cfg interface{}, filename string, src []byte) error {
 }
 
 func (l *Loader) LoadWithBytes(cfg interface{}, filename string, src []byte) error {
+	_, err := l.LoadBytesWithResult(cfg, filename, src)
+	return err
+}
+
+// LoadBytesWithResult is the same as LoadWithBytes, and returns the LoadResult.
+func LoadBytesWithResult(cfg interface{}, filename string, src []byte) (*LoadResult, error) {
+	return defaultLoader.LoadBytesWithResult(cfg, filename, src)
+}
+
+// LoadBytesWithResult is the same as LoadWithBytes, and returns the LoadResult.
+// The result is nil if the source can not be parsed.
+func (l *Loader) LoadBytesWithResult(cfg interface{}, filename string, src []byte) (*LoadResult, error) {
 	parser := hclparse.NewParser()
 	var diags hcl.Diagnostics
 	var file *hcl.File
@@ -232,9 +564,97 @@ func (l *Loader) LoadWithBytes(cfg interface{}, filename string, src []byte) err
 		diags = append(diags, NewDiagnosticError("invalid file format", "ext suffix must .json or .hcl", nil))
 	}
 	if diags.HasErrors() {
-		return l.writeDiags(diags, parser.Files())
+		return nil, l.writeDiags(diags, parser.Files())
+	}
+	rec := newLoadRecorder()
+	if body, ok := file.Body.(*hclsyntax.Body); ok {
+		rec.collect(body)
 	}
-	ctx := l.NewEvalContext(filepath.Dir(filename))
+	dir := filepath.Dir(filename)
+	ctx := l.newEvalContext(rec, dir)
 	diags = append(diags, l.LoadWithBody(cfg, ctx, file.Body)...)
-	return l.writeDiags(diags, parser.Files())
+	return l.newLoadResult(rec, ctx, dir), l.writeDiags(diags, parser.Files())
+}
+
+// RenderTemplate renders a template file with the given variables, and returns the result as a string.
+// It uses the functions of the default Loader.
+func RenderTemplate(path string, vars map[string]cty.Value) (string, hcl.Diagnostics) {
+	return defaultLoader.RenderTemplate(path, vars)
+}
+
+// RenderTemplate renders a template file with the given variables, and returns the result as a string.
+// It uses the functions of the Loader, and LoadResult.RenderTemplate also uses the locals and implied variables of a loaded configuration.
+// The template is searched in the template directories and the working directory.
+func (l *Loader) RenderTemplate(path string, vars map[string]cty.Value) (string, hcl.Diagnostics) {
+	return renderTemplate(l.newTemplateRenderer(l.NewEvalContext(), nil), path, vars)
+}
+
+// RenderTemplateFS renders a template file in fsys with the given variables, and returns the result as a string.
+// It uses the functions of the default Loader.
+func RenderTemplateFS(fsys fs.FS, path string, vars map[string]cty.Value) (string, hcl.Diagnostics) {
+	return defaultLoader.RenderTemplateFS(fsys, path, vars)
+}
+
+// RenderTemplateFS renders a template file in fsys with the given variables, and returns the result as a string.
+// It uses the functions of the Loader. template_include in the template also reads partials from fsys.
+func (l *Loader) RenderTemplateFS(fsys fs.FS, path string, vars map[string]cty.Value) (string, hcl.Diagnostics) {
+	return renderTemplate(l.newTemplateRenderer(l.NewEvalContext(), fsys), path, vars)
+}
+
+// RenderTemplate renders a template file with the given variables, and returns the result as a string.
+// It uses the same functions, locals and implied variables as the loaded configuration.
+// The template is searched in the load paths and the template directories, like templatefile.
+func (r *LoadResult) RenderTemplate(path string, vars map[string]cty.Value) (string, hcl.Diagnostics) {
+	return renderTemplate(r.newTemplateRenderer(nil), path, vars)
+}
+
+// RenderTemplateFS renders a template file in fsys with the given variables, and returns the result as a string.
+// It uses the same functions, locals and implied variables as the loaded configuration.
+// template_include in the template also reads partials from fsys.
+func (r *LoadResult) RenderTemplateFS(fsys fs.FS, path string, vars map[string]cty.Value) (string, hcl.Diagnostics) {
+	return renderTemplate(r.newTemplateRenderer(fsys), path, vars)
+}
+
+func (l *Loader) newTemplateRenderer(ctx *hcl.EvalContext, fsys fs.FS, paths ...string) *templateRenderer {
+	return &templateRenderer{
+		newEvalContext: func() *hcl.EvalContext {
+			return ctx
+		},
+		resolver: l.templateResolver(paths...),
+		fsys:     fsys,
+		maxDepth: l.templateMaxDepth,
+		strict:   l.strictTemplates,
+	}
+}
+
+func renderTemplate(t *templateRenderer, path string, vars map[string]cty.Value) (string, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	resolved, err := t.resolve("", path)
+	if err != nil {
+		diags = append(diags, NewDiagnosticError("Template not found", err.Error(), nil))
+		return "", diags
+	}
+	value, err := t.render(resolved, vars)
+	if err != nil {
+		var renderDiags hcl.Diagnostics
+		if errors.As(err, &renderDiags) {
+			return "", append(diags, renderDiags...)
+		}
+		diags = append(diags, NewDiagnosticError("Failed to render template", err.Error(), nil))
+		return "", diags
+	}
+	value, _ = value.UnmarkDeep()
+	if !value.IsWhollyKnown() {
+		diags = append(diags, NewDiagnosticError("Failed to render template", fmt.Sprintf("The result of %s is unknown", resolved), nil))
+		return "", diags
+	}
+	str, err := convert.Convert(value, cty.String)
+	if err != nil {
+		diags = append(diags, NewDiagnosticError("Failed to render template", fmt.Sprintf("The result of %s can not be converted to string: %s", resolved, err), nil))
+		return "", diags
+	}
+	if str.IsNull() {
+		return "", diags
+	}
+	return str.AsString(), diags
 }
@@ -2,15 +2,22 @@ package hclconfig_test
 
 import (
 	"fmt"
-	"os"
+	"net"
+	"net/url"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/mashiike/hclconfig"
 	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
 )
 
 type Config struct {
@@ -137,7 +144,6 @@ func ptr[T any](v T) *T {
 }
 
 func TestLoadNoError(t *testing.T) {
-	os.Setenv("PORT", "8000")
 	cases := []struct {
 		path  string
 		check func(t *testing.T, cfg *Config)
@@ -261,6 +267,9 @@ func TestLoadNoError(t *testing.T) {
 	for _, c := range cases {
 		t.Run(c.path, func(t *testing.T) {
 			loader := hclconfig.New()
+			loader.Environment(map[string]string{
+				"PORT": "8000",
+			})
 			loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
 				t.Log(convertDiagnosticToString(diag))
 				return nil
@@ -382,3 +391,993 @@ func TestBodyDecoder(t *testing.T) {
 		"description": "this is hoge\n",
 	}, d.data)
 }
+
+func TestLoadReport(t *testing.T) {
+	loader := hclconfig.New()
+	loader.Environment(map[string]string{
+		"PORT": "8000",
+	})
+	loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
+		t.Log(convertDiagnosticToString(diag))
+		return nil
+	}))
+	var cfg Config
+	result, err := loader.LoadWithResult(&cfg, "testdata/sample")
+	require.NoError(t, err)
+	report := result.Report
+	require.NotNil(t, report)
+	envs := make([]string, 0, len(report.Envs))
+	for _, env := range report.Envs {
+		envs = append(envs, fmt.Sprintf("%s set=%v on %s", env.Name, env.Set, env.Range.String()))
+	}
+	require.EqualValues(t, []string{
+		"ADDR set=false on testdata/sample/config.hcl:5,10-41",
+		"PORT set=true on testdata/sample/config.hcl:6,19-38",
+		"ADDR set=false on testdata/sample/config.hcl:10,10-41",
+		"PORT set=true on testdata/sample/extend.hcl:8,19-35",
+	}, envs)
+	functions := make([]string, 0, len(report.Functions))
+	for _, f := range report.Functions {
+		functions = append(functions, fmt.Sprintf("%s on %s", f.Name, f.Range.String()))
+	}
+	require.EqualValues(t, []string{
+		"env on testdata/sample/config.hcl:5,10-41",
+		"parseint on testdata/sample/config.hcl:6,10-43",
+		"env on testdata/sample/config.hcl:6,19-38",
+		"env on testdata/sample/config.hcl:10,10-41",
+		"parseint on testdata/sample/extend.hcl:8,10-40",
+		"must_env on testdata/sample/extend.hcl:8,19-35",
+	}, functions)
+	require.Empty(t, report.Files)
+
+	result, err = loader.LoadWithResult(&cfg, "testdata/templatefile")
+	require.NoError(t, err)
+	report = result.Report
+	files := make([]string, 0, len(report.Files))
+	for _, f := range report.Files {
+		files = append(files, fmt.Sprintf("%s on %s", f.Path, f.Range.String()))
+	}
+	require.EqualValues(t, []string{
+		"testdata/templatefile/template/addr.hcl on testdata/templatefile/config.hcl:8,20-72",
+	}, files)
+}
+
+type reportConfig struct {
+	Name string   `hcl:"name"`
+	Tags []string `hcl:"tags,optional"`
+}
+
+func TestLoadReportRecordsCalls(t *testing.T) {
+	var count int
+	loader := hclconfig.New()
+	loader.Environment(map[string]string{
+		"NAME": "hoge",
+	})
+	loader.Functions(map[string]function.Function{
+		"key": function.New(&function.Spec{
+			Type: function.StaticReturnType(cty.String),
+			Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+				count++
+				return cty.StringVal("NAME"), nil
+			},
+		}),
+	})
+	loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
+		t.Log(convertDiagnosticToString(diag))
+		return nil
+	}))
+	src := []byte(`
+name = env(key(), "fuga")
+tags = [for tag in [] : env("NEVER")]
+`)
+	file, diags := hclsyntax.ParseConfig(src, "testdata/config.hcl", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	diags = loader.LoadWithBody(&reportConfig{}, loader.NewEvalContext("testdata"), file.Body)
+	require.False(t, diags.HasErrors())
+	evaluated := count
+
+	count = 0
+	var cfg reportConfig
+	result, err := loader.LoadBytesWithResult(&cfg, "testdata/config.hcl", src)
+	require.NoError(t, err)
+	require.Equal(t, "hoge", cfg.Name)
+	require.Equal(t, evaluated, count, "the functions are called only by the evaluation")
+	report := result.Report
+	require.EqualValues(t, []hclconfig.EnvReference{
+		{
+			Name: "NAME",
+			Set:  true,
+			Range: hcl.Range{
+				Filename: "testdata/config.hcl",
+				Start:    hcl.Pos{Line: 2, Column: 8, Byte: 8},
+				End:      hcl.Pos{Line: 2, Column: 26, Byte: 26},
+			},
+		},
+	}, report.Envs)
+	functions := make([]string, 0, len(report.Functions))
+	for _, f := range report.Functions {
+		functions = append(functions, fmt.Sprintf("%s on %s", f.Name, f.Range.String()))
+	}
+	require.EqualValues(t, []string{
+		"env on testdata/config.hcl:2,8-26",
+		"key on testdata/config.hcl:2,12-17",
+	}, functions)
+
+	cfg = reportConfig{}
+	result, err = loader.LoadBytesWithResult(&cfg, "testdata/config.hcl.json", []byte(`{"name": "${must_env(\"NAME\")}"}`))
+	require.NoError(t, err)
+	require.Equal(t, "hoge", cfg.Name)
+	require.EqualValues(t, []hclconfig.EnvReference{{Name: "NAME", Set: true}}, result.Report.Envs)
+}
+
+type reportRestricted struct {
+	Name string `hcl:"name"`
+
+	Called string
+	Upper  string
+}
+
+func (r *reportRestricted) Restrict(content *hcl.BodyContent, ctx *hcl.EvalContext) hcl.Diagnostics {
+	expr := content.Attributes["name"].Expr
+	r.Called = expr.(*hclsyntax.FunctionCallExpr).Name
+	value, diags := expr.Value(&hcl.EvalContext{
+		Functions: map[string]function.Function{
+			"upper": stdlib.UpperFunc,
+		},
+	})
+	if diags.HasErrors() {
+		return diags
+	}
+	r.Upper = value.AsString()
+	return nil
+}
+
+func TestLoadReportKeepsExpressions(t *testing.T) {
+	var cfg reportRestricted
+	result, err := hclconfig.New().LoadBytesWithResult(&cfg, "testdata/config.hcl", []byte(`name = upper("hoge")`))
+	require.NoError(t, err)
+	require.Equal(t, "upper", cfg.Called)
+	require.Equal(t, "HOGE", cfg.Upper)
+	require.Len(t, result.Report.Functions, 1)
+	require.Equal(t, "upper", result.Report.Functions[0].Name)
+}
+
+func TestRenderTemplate(t *testing.T) {
+	loader := hclconfig.New()
+	var cfg Config
+	result, err := loader.LoadWithResult(&cfg, "testdata/templatefile")
+	require.NoError(t, err)
+
+	str, diags := result.RenderTemplate("template/banner.tmpl", map[string]cty.Value{
+		"name": cty.StringVal("tora"),
+	})
+	require.False(t, diags.HasErrors(), diags.Error())
+	require.Equal(t, "PROD:tora", str)
+
+	fsys := fstest.MapFS{
+		"mail/body.tmpl":   {Data: []byte(`${template_include("header.tmpl")}env=${local.env}`)},
+		"mail/header.tmpl": {Data: []byte(`Hi ${name}, `)},
+	}
+	str, diags = result.RenderTemplateFS(fsys, "mail/body.tmpl", map[string]cty.Value{
+		"name": cty.StringVal("tora"),
+	})
+	require.False(t, diags.HasErrors(), diags.Error())
+	require.Equal(t, "Hi tora, env=prod", str)
+
+	_, diags = result.RenderTemplate("template/not_found.tmpl", nil)
+	require.True(t, diags.HasErrors())
+
+	str, diags = loader.RenderTemplateFS(fsys, "mail/header.tmpl", map[string]cty.Value{
+		"name": cty.StringVal("tora"),
+	})
+	require.False(t, diags.HasErrors(), diags.Error())
+	require.Equal(t, "Hi tora, ", str)
+
+	_, diags = loader.RenderTemplateFS(fsys, "mail/body.tmpl", map[string]cty.Value{
+		"name": cty.StringVal("tora"),
+	})
+	require.True(t, diags.HasErrors(), "the locals are only available in the result of a load")
+}
+
+type testLogLevel int
+
+func (l *testLogLevel) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "debug":
+		*l = 0
+	case "info":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown log level %q", string(text))
+	}
+	return nil
+}
+
+type StandardTypesConfig struct {
+	Timeout   time.Duration            `hcl:"timeout"`
+	Intervals []time.Duration          `hcl:"intervals,optional"`
+	StartAt   time.Time                `hcl:"start_at,optional"`
+	Listen    net.IP                   `hcl:"listen,optional"`
+	Peers     map[string]net.IP        `hcl:"peers,optional"`
+	Endpoint  url.URL                  `hcl:"endpoint,optional"`
+	Proxy     *url.URL                 `hcl:"proxy,optional"`
+	LogLevel  testLogLevel             `hcl:"log_level,optional"`
+	Retries   map[string]time.Duration `hcl:"retries,optional"`
+}
+
+func TestLoadStandardTypes(t *testing.T) {
+	loader := hclconfig.New()
+	var cfg StandardTypesConfig
+	err := loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
+timeout   = "30s"
+intervals = ["1s", 60]
+start_at  = "2022-11-11T11:11:11Z"
+listen    = "127.0.0.1"
+peers     = { hoge = "192.0.2.1" }
+endpoint  = "https://example.com/api?q=1"
+proxy     = "http://proxy.example.com:8080"
+log_level = "info"
+retries   = { short = "100ms" }
+`))
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, cfg.Timeout)
+	require.Equal(t, []time.Duration{time.Second, time.Minute}, cfg.Intervals)
+	require.True(t, time.Date(2022, 11, 11, 11, 11, 11, 0, time.UTC).Equal(cfg.StartAt))
+	require.Equal(t, "127.0.0.1", cfg.Listen.String())
+	require.Equal(t, "192.0.2.1", cfg.Peers["hoge"].String())
+	require.Equal(t, "example.com", cfg.Endpoint.Host)
+	require.Equal(t, "1", cfg.Endpoint.Query().Get("q"))
+	require.NotNil(t, cfg.Proxy)
+	require.Equal(t, "proxy.example.com:8080", cfg.Proxy.Host)
+	require.Equal(t, testLogLevel(1), cfg.LogLevel)
+	require.Equal(t, map[string]time.Duration{"short": 100 * time.Millisecond}, cfg.Retries)
+
+	var diags []string
+	loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
+		if diag.Severity == hcl.DiagError {
+			diags = append(diags, convertDiagnosticToString(diag))
+		}
+		return nil
+	}))
+	err = loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
+timeout   = "30 seconds"
+listen    = "localhost"
+log_level = "trace"
+`))
+	require.Error(t, err)
+	require.ElementsMatch(t, []string{
+		`[error] on config.hcl:2,1-25: Invalid duration; time: unknown unit " seconds" in duration "30 seconds"`,
+		`[error] on config.hcl:3,1-24: Invalid value; Can not decode "localhost" into *net.IP: invalid IP address: localhost`,
+		`[error] on config.hcl:4,1-20: Invalid value; Can not decode "trace" into *hclconfig_test.testLogLevel: unknown log level "trace"`,
+	}, diags)
+}
+
+type DefaultsConfig struct {
+	Services []DefaultsServiceConfig `hcl:"service,block"`
+}
+
+type DefaultsServiceConfig struct {
+	Name     string         `hcl:"name,label"`
+	Addr     string         `hcl:"addr" default:"127.0.0.1"`
+	Port     int            `hcl:"port,optional" default:"8080"`
+	Timeout  time.Duration  `hcl:"timeout,optional" default:"30s"`
+	Idle     *time.Duration `hcl:"idle,optional" default:"5s"`
+	Tags     []string       `hcl:"tags,optional" default:"[\"web\"]"`
+	Protocol string         `hcl:"protocol,optional"`
+}
+
+func (s *DefaultsServiceConfig) SetDefaults() {
+	s.Protocol = "http"
+}
+
+func TestLoadDefaults(t *testing.T) {
+	loader := hclconfig.New()
+	var cfg DefaultsConfig
+	err := loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
+service "hoge" {
+}
+
+service "fuga" {
+  port     = service.hoge.port + 1
+  timeout  = 10
+  tags     = ["api"]
+  protocol = "${service.hoge.protocol}s"
+}
+
+service "piyo" {
+  timeout = service.hoge.timeout + service.fuga.timeout
+  idle    = service.hoge.idle * 2
+}
+`))
+	require.NoError(t, err)
+	require.EqualValues(t, []DefaultsServiceConfig{
+		{
+			Name:     "hoge",
+			Addr:     "127.0.0.1",
+			Port:     8080,
+			Timeout:  30 * time.Second,
+			Idle:     ptr(5 * time.Second),
+			Tags:     []string{"web"},
+			Protocol: "http",
+		},
+		{
+			Name:     "fuga",
+			Addr:     "127.0.0.1",
+			Port:     8081,
+			Timeout:  10 * time.Second,
+			Idle:     ptr(5 * time.Second),
+			Tags:     []string{"api"},
+			Protocol: "https",
+		},
+		{
+			Name:     "piyo",
+			Addr:     "127.0.0.1",
+			Port:     8080,
+			Timeout:  40 * time.Second,
+			Idle:     ptr(10 * time.Second),
+			Tags:     []string{"web"},
+			Protocol: "http",
+		},
+	}, cfg.Services)
+}
+
+type InvalidDefaultConfig struct {
+	Name string `hcl:"name"`
+	Port int    `hcl:"port,optional" default:"http"`
+}
+
+func TestLoadInvalidDefault(t *testing.T) {
+	loader := hclconfig.New()
+	var diags []string
+	loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
+		if diag.Severity == hcl.DiagError {
+			diags = append(diags, convertDiagnosticToString(diag))
+		}
+		return nil
+	}))
+	var cfg InvalidDefaultConfig
+	err := loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
+name = "hoge"
+`))
+	require.Error(t, err)
+	require.Len(t, diags, 1)
+	require.Contains(t, diags[0], `Invalid default tag; The default tag "http" on hclconfig_test.InvalidDefaultConfig field Port (attribute "port") is invalid: `)
+}
+
+type MapBlocksConfig struct {
+	Services map[string]map[string]ServiceConfig `hcl:"service,block"`
+	Workers  map[string]*WorkerConfig            `hcl:"worker,block"`
+}
+
+type WorkerConfig struct {
+	Queue       string `hcl:"queue"`
+	Concurrency int    `hcl:"concurrency,optional"`
+}
+
+func TestLoadMapBlocks(t *testing.T) {
+	loader := hclconfig.New()
+	var cfg MapBlocksConfig
+	err := loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
+service "http" "hoge" {
+  addr = "http://127.0.0.1"
+  port = 8080
+}
+
+service "http" "fuga" {
+  addr = service.http.hoge.addr
+  port = service.http.hoge.port + 1
+}
+
+service "grpc" "hoge" {
+  addr = "127.0.0.1"
+  port = 9090
+}
+
+worker "mail" {
+  queue       = "mail"
+  concurrency = 2
+}
+`))
+	require.NoError(t, err)
+	require.EqualValues(t, map[string]map[string]ServiceConfig{
+		"http": {
+			"hoge": {Type: "http", Name: "hoge", Addr: "http://127.0.0.1", Port: 8080, Range: "config.hcl:2,23-23"},
+			"fuga": {Type: "http", Name: "fuga", Addr: "http://127.0.0.1", Port: 8081, Range: "config.hcl:7,23-23"},
+		},
+		"grpc": {
+			"hoge": {Type: "grpc", Name: "hoge", Addr: "127.0.0.1", Port: 9090, Range: "config.hcl:12,23-23"},
+		},
+	}, cfg.Services)
+	require.EqualValues(t, map[string]*WorkerConfig{
+		"mail": {Queue: "mail", Concurrency: 2},
+	}, cfg.Workers)
+
+	var diags []string
+	loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
+		if diag.Severity == hcl.DiagError {
+			diags = append(diags, convertDiagnosticToString(diag))
+		}
+		return nil
+	}))
+	err = loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
+worker "mail" {
+  queue = "mail"
+}
+
+worker "mail" {
+  queue = "mail2"
+}
+`))
+	require.Error(t, err)
+	require.EqualValues(t, []string{
+		`[error] on config.hcl:6,1-14: Duplicate worker declaration; A worker named "mail" was already declared at config.hcl:2,1-14. worker names must unique within a configuration`,
+	}, diags)
+}
+
+type ServiceName string
+
+type NamedKeyConfig struct {
+	Services map[ServiceName]map[ServiceName]*NamedKeyService `hcl:"service,block"`
+}
+
+type NamedKeyService struct {
+	Type ServiceName `hcl:"type,label"`
+	Name ServiceName `hcl:"name,label"`
+	Port int         `hcl:"port"`
+}
+
+func (s *NamedKeyService) Restrict(content *hcl.BodyContent, ctx *hcl.EvalContext) hcl.Diagnostics {
+	if s.Port <= 0 {
+		return hcl.Diagnostics{hclconfig.NewDiagnosticError("Invalid port", "The port must be positive.", hclconfig.AttributeRange(content, "port"))}
+	}
+	return nil
+}
+
+func TestLoadMapBlocksNamedKey(t *testing.T) {
+	loader := hclconfig.New()
+	var diags []string
+	loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
+		if diag.Severity == hcl.DiagError {
+			diags = append(diags, convertDiagnosticToString(diag))
+		}
+		return nil
+	}))
+	var cfg NamedKeyConfig
+	err := loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
+service "http" "hoge" {
+  port = 8080
+}
+`))
+	require.NoError(t, err)
+	require.EqualValues(t, map[ServiceName]map[ServiceName]*NamedKeyService{
+		"http": {
+			"hoge": {Type: "http", Name: "hoge", Port: 8080},
+		},
+	}, cfg.Services)
+
+	err = loader.LoadWithBytes(&NamedKeyConfig{}, "config.hcl", []byte(`
+service "http" "hoge" {
+  port = 0
+}
+`))
+	require.Error(t, err)
+	require.EqualValues(t, []string{
+		"[error] on config.hcl:3,3-11: Invalid port; The port must be positive.",
+	}, diags)
+}
+
+type SliceRootApp struct {
+	Name string `hcl:"name"`
+	Port int    `hcl:"port"`
+
+	Restricted bool
+}
+
+func (a *SliceRootApp) Restrict(content *hcl.BodyContent, ctx *hcl.EvalContext) hcl.Diagnostics {
+	a.Restricted = true
+	var diags hcl.Diagnostics
+	if a.Port <= 0 {
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid port",
+			Detail:   "The port must be positive.",
+			Subject:  content.Attributes["port"].Range.Ptr(),
+		})
+	}
+	return diags
+}
+
+func TestLoadSliceRoot(t *testing.T) {
+	loader := hclconfig.New()
+	var diags []string
+	loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
+		if diag.Severity == hcl.DiagError {
+			diags = append(diags, convertDiagnosticToString(diag))
+		}
+		return nil
+	}))
+	var apps []SliceRootApp
+	err := loader.Load(&apps, "testdata/slice")
+	require.NoError(t, err)
+	require.EqualValues(t, []SliceRootApp{
+		{Name: "api", Port: 8080, Restricted: true},
+		{Name: "web", Port: 8080, Restricted: true},
+	}, apps)
+
+	var ptrs []*SliceRootApp
+	err = loader.LoadWithBytes(&ptrs, "config.hcl", []byte(`
+name = "api"
+port = 0
+`))
+	require.Error(t, err)
+	require.Empty(t, ptrs)
+	require.EqualValues(t, []string{
+		"[error] on config.hcl:3,1-9: Invalid port; The port must be positive.",
+	}, diags)
+}
+
+type EmbeddedCommon struct {
+	Name    string `hcl:"name,label"`
+	Enabled bool   `hcl:"enabled,optional" default:"true"`
+
+	Restricted bool
+}
+
+func (c *EmbeddedCommon) Restrict(content *hcl.BodyContent, ctx *hcl.EvalContext) hcl.Diagnostics {
+	c.Restricted = true
+	return nil
+}
+
+type EmbeddedExtra struct {
+	Region string `hcl:"region,optional"`
+}
+
+type EmbeddedService struct {
+	EmbeddedCommon
+	*EmbeddedExtra
+	Addr string `hcl:"addr"`
+	Port int    `hcl:"port" validate:"min=1"`
+}
+
+type EmbeddedConfig struct {
+	Services []EmbeddedService `hcl:"service,block"`
+	Flag     bool              `hcl:"flag"`
+}
+
+func TestLoadEmbedded(t *testing.T) {
+	loader := hclconfig.New()
+	var cfg EmbeddedConfig
+	err := loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
+flag = service.fuga.enabled
+
+service "hoge" {
+  addr    = "http://127.0.0.1"
+  port    = 8080
+  enabled = false
+  region  = "ap-northeast-1"
+}
+
+service "fuga" {
+  addr = service.hoge.addr
+  port = service.hoge.port + 1
+}
+`))
+	require.NoError(t, err)
+	require.True(t, cfg.Flag)
+	require.EqualValues(t, []EmbeddedService{
+		{
+			EmbeddedCommon: EmbeddedCommon{Name: "hoge", Enabled: false, Restricted: true},
+			EmbeddedExtra:  &EmbeddedExtra{Region: "ap-northeast-1"},
+			Addr:           "http://127.0.0.1",
+			Port:           8080,
+		},
+		{
+			EmbeddedCommon: EmbeddedCommon{Name: "fuga", Enabled: true, Restricted: true},
+			Addr:           "http://127.0.0.1",
+			Port:           8081,
+		},
+	}, cfg.Services)
+}
+
+type EmbeddedBlocks struct {
+	Logging EmbeddedLogging `hcl:"logging,block"`
+}
+
+type EmbeddedLogging struct {
+	Level string `hcl:"level"`
+}
+
+type EmbeddedBlocksConfig struct {
+	*EmbeddedBlocks
+	Name string `hcl:"name"`
+}
+
+func TestLoadEmbeddedRequiredBlock(t *testing.T) {
+	loader := hclconfig.New()
+	var diags []string
+	loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
+		if diag.Severity == hcl.DiagError {
+			diags = append(diags, convertDiagnosticToString(diag))
+		}
+		return nil
+	}))
+	var cfg EmbeddedBlocksConfig
+	err := loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
+name = "hoge"
+
+logging {
+  level = "info"
+}
+`))
+	require.NoError(t, err)
+	require.Equal(t, "info", cfg.Logging.Level)
+
+	err = loader.LoadWithBytes(&EmbeddedBlocksConfig{}, "config.hcl", []byte(`
+name = "hoge"
+`))
+	require.Error(t, err)
+	require.EqualValues(t, []string{
+		"[error] on config.hcl:1,1-1: Missing logging block; A logging block is required.",
+	}, diags)
+}
+
+type PolymorphicConfig struct {
+	Payloads []*PolymorphicPayload `hcl:"payload,block"`
+	Summary  string                `hcl:"summary,optional"`
+}
+
+type PolymorphicPayload struct {
+	Type string `hcl:"type,label"`
+	Name string `hcl:"name,label"`
+
+	Spec hclconfig.Polymorphic[fmt.Stringer] `hcl:",remain"`
+}
+
+func init() {
+	hclconfig.RegisterBlockType("payload", "string", func() fmt.Stringer {
+		return &FlexibleString{}
+	})
+	hclconfig.RegisterBlockType("payload", "integer", func() fmt.Stringer {
+		return &FlexibleInt{}
+	})
+}
+
+func TestLoadPolymorphic(t *testing.T) {
+	loader := hclconfig.New()
+	var cfg PolymorphicConfig
+	err := loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
+payload "string" "hoge" {
+  text = "hello"
+}
+
+payload "integer" "fuga" {
+  number = 42
+}
+
+summary = "${payload.string.hoge.text}:${payload.integer.fuga.number}"
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.Payloads, 2)
+	require.EqualValues(t, &FlexibleString{Text: "hello"}, cfg.Payloads[0].Spec.Get())
+	require.EqualValues(t, &FlexibleInt{Number: 42}, cfg.Payloads[1].Spec.Get())
+	require.Equal(t, "hello:42", cfg.Summary)
+
+	var diags []string
+	loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
+		if diag.Severity == hcl.DiagError {
+			diags = append(diags, convertDiagnosticToString(diag))
+		}
+		return nil
+	}))
+	err = loader.LoadWithBytes(&cfg, "config.hcl", []byte(`
+payload "float" "hoge" {
+  value = 1.5
+}
+
+payload "integer" "fuga" {
+  text = "hello"
+}
+`))
+	require.Error(t, err)
+	require.EqualValues(t, []string{
+		`[error] on config.hcl:2,9-16: Unknown payload type; The payload type "float" is not registered. Valid types are "integer", "string".`,
+		`[error] on config.hcl:6,26-26: Missing required argument; The argument "number" is required, but no definition was found.`,
+		`[error] on config.hcl:7,3-7: Unsupported argument; An argument named "text" is not expected here.`,
+	}, diags)
+}
+
+type SourceConfig struct {
+	Source   hclconfig.Source       `hcl:",range"`
+	IOMode   string                 `hcl:"io_mode"`
+	Services []*SourceServiceConfig `hcl:"service,block"`
+}
+
+type SourceServiceConfig struct {
+	Type   string            `hcl:"type,label"`
+	Name   string            `hcl:"name,label"`
+	Addr   string            `hcl:"addr"`
+	Port   int               `hcl:"port,optional"`
+	Source *hclconfig.Source `hcl:",range"`
+}
+
+func TestLoadSource(t *testing.T) {
+	var cfg SourceConfig
+	err := hclconfig.LoadWithBytes(&cfg, "config.hcl", []byte(`io_mode = "readonly"
+
+service "http" "hoge" {
+  addr = "http://127.0.0.1"
+  port = 8080
+}
+`))
+	require.NoError(t, err)
+	require.Equal(t, "defined at config.hcl:1", cfg.Source.String())
+	require.Equal(t, "config.hcl:1,1-21", cfg.Source.AttributeRange("io_mode").String())
+
+	require.Len(t, cfg.Services, 1)
+	source := cfg.Services[0].Source
+	require.Equal(t, "service", source.BlockType)
+	require.Equal(t, []string{"http", "hoge"}, source.Labels)
+	require.Equal(t, "config.hcl", source.Filename)
+	require.Equal(t, "service.http.hoge defined at config.hcl:3", source.String())
+	require.Equal(t, "config.hcl:3,1-22", source.DefRange.String())
+	require.Equal(t, "config.hcl:4,3-28", source.AttributeRange("addr").String())
+	require.Equal(t, "config.hcl:5,3-14", source.AttributeRange("port").String())
+}
+
+type AnyConfig struct {
+	Plugins []*AnyPluginConfig `hcl:"plugin,block"`
+}
+
+type AnyPluginConfig struct {
+	Name     string                 `hcl:"name,label"`
+	Default  any                    `hcl:"default,optional"`
+	Settings map[string]any         `hcl:"settings,optional"`
+	Args     []any                  `hcl:"args,optional"`
+	Extra    map[string]interface{} `hcl:",remain"`
+}
+
+func TestLoadAny(t *testing.T) {
+	var cfg AnyConfig
+	err := hclconfig.LoadWithBytes(&cfg, "config.hcl", []byte(`
+locals {
+  region = "ap-northeast-1"
+}
+
+plugin "s3" {
+  default  = 1.5
+  settings = {
+    bucket = "hoge"
+    region = local.region
+    retry  = 3
+    tags   = ["a", "b"]
+    acl    = null
+  }
+  args  = ["--dry-run", true, 10]
+  debug = true
+}
+
+plugin "stdout" {
+  default = { format = "json" }
+}
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.Plugins, 2)
+	require.EqualValues(t, &AnyPluginConfig{
+		Name:    "s3",
+		Default: 1.5,
+		Settings: map[string]any{
+			"bucket": "hoge",
+			"region": "ap-northeast-1",
+			"retry":  int64(3),
+			"tags":   []any{"a", "b"},
+			"acl":    nil,
+		},
+		Args:  []any{"--dry-run", true, int64(10)},
+		Extra: map[string]interface{}{"debug": true},
+	}, cfg.Plugins[0])
+	require.EqualValues(t, &AnyPluginConfig{
+		Name:    "stdout",
+		Default: map[string]any{"format": "json"},
+		Extra:   map[string]interface{}{},
+	}, cfg.Plugins[1])
+}
+
+type RefConfig struct {
+	Services []*ServiceConfig `hcl:"service,block"`
+	Routes   []*RouteConfig   `hcl:"route,block"`
+}
+
+type RouteConfig struct {
+	Path     string                       `hcl:"path,label"`
+	Upstream hclconfig.Ref[ServiceConfig] `hcl:"upstream"`
+	Fallback hclconfig.Ref[ServiceConfig] `hcl:"fallback,optional"`
+	Parent   *hclconfig.Ref[RouteConfig]  `hcl:"parent,optional"`
+}
+
+func TestLoadRef(t *testing.T) {
+	var cfg RefConfig
+	err := hclconfig.LoadWithBytes(&cfg, "config.hcl", []byte(`
+service "http" "hoge" {
+  addr = "http://127.0.0.1"
+  port = 8080
+}
+
+service "http" "fuga" {
+  addr = "http://127.0.0.1"
+  port = 8081
+}
+
+route "api" {
+  upstream = service.http.hoge
+  fallback = service["http"]["fuga"]
+}
+
+route "web" {
+  upstream = service.http.fuga
+  parent   = route.api
+}
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.Routes, 2)
+	require.Same(t, cfg.Services[0], cfg.Routes[0].Upstream.Get())
+	require.Same(t, cfg.Services[1], cfg.Routes[0].Fallback.Get())
+	require.Equal(t, "service.http.hoge", cfg.Routes[0].Upstream.Name())
+	require.Equal(t, "config.hcl:13,14-31", cfg.Routes[0].Upstream.Range().String())
+	require.Same(t, cfg.Services[1], cfg.Routes[1].Upstream.Get())
+	require.Nil(t, cfg.Routes[1].Fallback.Get())
+	require.Same(t, cfg.Routes[0], cfg.Routes[1].Parent.Get())
+
+	var diags []string
+	loader := hclconfig.New()
+	loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
+		if diag.Severity == hcl.DiagError {
+			diags = append(diags, convertDiagnosticToString(diag))
+		}
+		return nil
+	}))
+	err = loader.LoadWithBytes(&RefConfig{}, "config.hcl", []byte(`
+service "http" "hoge" {
+  addr = "http://127.0.0.1"
+  port = 8080
+}
+
+route "api" {
+  upstream = service.http.hogee
+}
+
+route "web" {
+  upstream = route.api
+}
+
+route "admin" {
+  upstream = "service.http.hoge"
+}
+`))
+	require.Error(t, err)
+	require.EqualValues(t, []string{
+		`[error] on config.hcl:16,14-33: Invalid expression; A single static variable reference is required: only attribute access and indexing with constant keys. No calculations, function calls, template expressions, etc are allowed here.`,
+	}, diags)
+
+	diags = nil
+	err = loader.LoadWithBytes(&RefConfig{}, "config.hcl", []byte(`
+service "http" "hoge" {
+  addr = "http://127.0.0.1"
+  port = 8080
+}
+
+route "api" {
+  upstream = service.http.hogee
+}
+
+route "web" {
+  upstream = route.api
+}
+`))
+	require.Error(t, err)
+	require.EqualValues(t, []string{
+		`[error] on config.hcl:8,14-32: Reference to undeclared block; A block service.http.hogee has not been declared.`,
+		`[error] on config.hcl:12,14-23: Invalid reference; route.api is hclconfig_test.RouteConfig, not hclconfig_test.ServiceConfig.`,
+	}, diags)
+}
+
+type ValidatorConfig struct {
+	Services []*ServiceConfig  `hcl:"service,block"`
+	Routes   []*ValidatorRoute `hcl:"route,block"`
+}
+
+type ValidatorRoute struct {
+	Path    string `hcl:"path,label"`
+	Service string `hcl:"service"`
+	Port    int    `hcl:"port"`
+
+	Name string
+}
+
+func (r *ValidatorRoute) Validate(vctx *hclconfig.ValidationContext) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	r.Name = vctx.Name()
+	root := vctx.Root.(*ValidatorConfig)
+	if vctx.Parent() != root {
+		panic("parent must be the root")
+	}
+	found := false
+	for _, s := range root.Services {
+		if s.Name == r.Service {
+			found = true
+			break
+		}
+	}
+	if !found {
+		diags = append(diags, hclconfig.NewDiagnosticError(
+			"Unknown service",
+			fmt.Sprintf("service %q is not declared", r.Service),
+			vctx.AttributeRange("service"),
+		))
+	}
+	for _, other := range root.Routes {
+		if other == r {
+			break
+		}
+		if other.Port == r.Port {
+			diags = append(diags, hclconfig.NewDiagnosticError(
+				"Duplicate port",
+				fmt.Sprintf("port %d is already used by route %q", r.Port, other.Path),
+				vctx.AttributeRange("port"),
+			))
+		}
+	}
+	return diags
+}
+
+func TestLoadValidator(t *testing.T) {
+	var cfg ValidatorConfig
+	err := hclconfig.LoadWithBytes(&cfg, "config.hcl", []byte(`
+service "http" "hoge" {
+  addr = "http://127.0.0.1"
+  port = 8080
+}
+
+route "api" {
+  service = "hoge"
+  port    = 80
+}
+
+route "web" {
+  service = "hoge"
+  port    = 8000
+}
+`))
+	require.NoError(t, err)
+	require.Equal(t, "route.api", cfg.Routes[0].Name)
+	require.Equal(t, "route.web", cfg.Routes[1].Name)
+
+	var diags []string
+	loader := hclconfig.New()
+	loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
+		if diag.Severity == hcl.DiagError {
+			diags = append(diags, convertDiagnosticToString(diag))
+		}
+		return nil
+	}))
+	err = loader.LoadWithBytes(&ValidatorConfig{}, "config.hcl", []byte(`
+service "http" "hoge" {
+  addr = "http://127.0.0.1"
+  port = 8080
+}
+
+route "api" {
+  service = "hoge"
+  port    = 80
+}
+
+route "web" {
+  service = "fuga"
+  port    = 80
+}
+`))
+	require.Error(t, err)
+	require.EqualValues(t, []string{
+		`[error] on config.hcl:13,3-19: Unknown service; service "fuga" is not declared`,
+		`[error] on config.hcl:14,3-15: Duplicate port; port 80 is already used by route "api"`,
+	}, diags)
+}
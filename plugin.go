@@ -0,0 +1,359 @@
+package hclconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// PluginProtocolVersion is the version of the plugin protocol.
+//
+// A plugin is an executable that reads one JSON request per line from stdin and writes one JSON response per line to stdout.
+// The first request is a handshake, and the plugin responds with the signatures of its functions:
+//
+//	-> {"method":"handshake","protocol_version":1}
+//	<- {"protocol_version":1,"functions":[{"name":"lookup","params":[{"name":"key","type":"string"}],"return_type":"string"}]}
+//
+// After that, each function call is sent as a call request, with arguments encoded as cty JSON values with their types:
+//
+//	-> {"method":"call","name":"lookup","args":[{"value":"hoge","type":"string"}]}
+//	<- {"value":"fuga","type":"string"}
+//	<- {"error":"hoge not found"}
+//
+// The type of a response value may be omitted, in which case the declared return type is used.
+const PluginProtocolVersion = 1
+
+const (
+	// DefaultPluginTimeout is the default maximum time to wait for a response from a plugin.
+	DefaultPluginTimeout = 30 * time.Second
+	// pluginCloseTimeout is the time to wait for the plugin to exit after closing stdin, before killing it.
+	pluginCloseTimeout = time.Second
+)
+
+// PluginOptions is the options of StartPluginWithOptions.
+type PluginOptions struct {
+	// Timeout is the maximum time to wait for the handshake and each call. If zero, DefaultPluginTimeout is used.
+	// The plugin process is killed on timeout, and the later calls fail.
+	Timeout time.Duration
+	// Stderr is the writer of the standard error of the plugin. If nil, os.Stderr is used.
+	Stderr io.Writer
+}
+
+type pluginRequest struct {
+	Method          string        `json:"method"`
+	ProtocolVersion int           `json:"protocol_version,omitempty"`
+	Name            string        `json:"name,omitempty"`
+	Args            []pluginValue `json:"args,omitempty"`
+}
+
+type pluginValue struct {
+	Value json.RawMessage `json:"value"`
+	Type  json.RawMessage `json:"type,omitempty"`
+}
+
+type pluginHandshakeResponse struct {
+	ProtocolVersion int                  `json:"protocol_version"`
+	Functions       []pluginFunctionSpec `json:"functions"`
+}
+
+type pluginFunctionSpec struct {
+	Name        string                `json:"name"`
+	Description string                `json:"description,omitempty"`
+	Params      []pluginParameterSpec `json:"params"`
+	VarParam    *pluginParameterSpec  `json:"var_param,omitempty"`
+	ReturnType  json.RawMessage       `json:"return_type"`
+}
+
+type pluginParameterSpec struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Type        json.RawMessage `json:"type"`
+	AllowNull   bool            `json:"allow_null,omitempty"`
+}
+
+type pluginCallResponse struct {
+	Value json.RawMessage `json:"value,omitempty"`
+	Type  json.RawMessage `json:"type,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// Plugin represents a running function plugin process.
+type Plugin struct {
+	// mu serializes the round trips.
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	encoder   *json.Encoder
+	decoder   *json.Decoder
+	functions map[string]function.Function
+	timeout   time.Duration
+
+	// done is closed when the plugin is closed or killed.
+	done     chan struct{}
+	doneOnce sync.Once
+	// exited is closed when the plugin process exits, and waitErr is the result of Wait.
+	exited  chan struct{}
+	waitErr error
+}
+
+// StartPlugin spawns the plugin executable and discovers its functions through the handshake.
+// The plugin process keeps running until Close is called.
+//
+//	plugin, err := hclconfig.StartPlugin("./lookup-plugin.py")
+//	if err != nil {
+//		return err
+//	}
+//	defer plugin.Close()
+//	hclconfig.Functions(plugin.Functions())
+func StartPlugin(name string, args ...string) (*Plugin, error) {
+	return StartPluginWithOptions(PluginOptions{}, name, args...)
+}
+
+// StartPluginWithOptions is StartPlugin with the options such as the timeout of calls.
+func StartPluginWithOptions(opts PluginOptions, name string, args ...string) (*Plugin, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = opts.Stderr
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start plugin %s: %w", name, err)
+	}
+	p := &Plugin{
+		cmd:     cmd,
+		stdin:   stdin,
+		encoder: json.NewEncoder(stdin),
+		decoder: json.NewDecoder(stdout),
+		timeout: opts.Timeout,
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+	if p.timeout <= 0 {
+		p.timeout = DefaultPluginTimeout
+	}
+	go func() {
+		p.waitErr = cmd.Wait()
+		close(p.exited)
+	}()
+	if err := p.handshake(); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("plugin %s handshake: %w", name, err)
+	}
+	return p, nil
+}
+
+func (p *Plugin) handshake() error {
+	var resp pluginHandshakeResponse
+	err := p.roundTrip(&pluginRequest{
+		Method:          "handshake",
+		ProtocolVersion: PluginProtocolVersion,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+	if resp.ProtocolVersion != PluginProtocolVersion {
+		return fmt.Errorf("unsupported protocol version %d", resp.ProtocolVersion)
+	}
+	p.functions = make(map[string]function.Function, len(resp.Functions))
+	for _, spec := range resp.Functions {
+		f, err := p.newFunction(spec)
+		if err != nil {
+			return fmt.Errorf("function %s: %w", spec.Name, err)
+		}
+		p.functions[spec.Name] = f
+	}
+	return nil
+}
+
+// roundTrip sends the request and reads the response, within the timeout.
+// The response is read in a goroutine, so a hung plugin is killed instead of blocking the caller.
+func (p *Plugin) roundTrip(req *pluginRequest, resp interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		return errors.New("plugin is closed")
+	default:
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		if err := p.encoder.Encode(req); err != nil {
+			errCh <- fmt.Errorf("write request: %w", err)
+			return
+		}
+		if err := p.decoder.Decode(resp); err != nil {
+			errCh <- fmt.Errorf("read response: %w", err)
+			return
+		}
+		errCh <- nil
+	}()
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-errCh:
+		return err
+	case <-timer.C:
+		p.kill()
+		return fmt.Errorf("no response in %s, the plugin is killed", p.timeout)
+	case <-p.done:
+		return errors.New("plugin is closed")
+	}
+}
+
+// kill marks the plugin as closed and kills the process.
+func (p *Plugin) kill() {
+	p.doneOnce.Do(func() {
+		close(p.done)
+		p.stdin.Close()
+		p.cmd.Process.Kill()
+	})
+}
+
+func (p *Plugin) newFunction(spec pluginFunctionSpec) (function.Function, error) {
+	params := make([]function.Parameter, 0, len(spec.Params))
+	for _, paramSpec := range spec.Params {
+		param, err := paramSpec.parameter()
+		if err != nil {
+			return function.Function{}, err
+		}
+		params = append(params, param)
+	}
+	var varParam *function.Parameter
+	if spec.VarParam != nil {
+		param, err := spec.VarParam.parameter()
+		if err != nil {
+			return function.Function{}, err
+		}
+		varParam = &param
+	}
+	retType, err := ctyjson.UnmarshalType(spec.ReturnType)
+	if err != nil {
+		return function.Function{}, fmt.Errorf("return type: %w", err)
+	}
+	name := spec.Name
+	return function.New(&function.Spec{
+		Description: spec.Description,
+		Params:      params,
+		VarParam:    varParam,
+		Type:        function.StaticReturnType(retType),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			return p.call(name, args, retType)
+		},
+	}), nil
+}
+
+func (s pluginParameterSpec) parameter() (function.Parameter, error) {
+	ty, err := ctyjson.UnmarshalType(s.Type)
+	if err != nil {
+		return function.Parameter{}, fmt.Errorf("parameter %s: %w", s.Name, err)
+	}
+	return function.Parameter{
+		Name:             s.Name,
+		Description:      s.Description,
+		Type:             ty,
+		AllowNull:        s.AllowNull,
+		AllowDynamicType: ty == cty.DynamicPseudoType,
+		AllowMarked:      true,
+	}, nil
+}
+
+func (p *Plugin) call(name string, args []cty.Value, retType cty.Type) (cty.Value, error) {
+	req := &pluginRequest{
+		Method: "call",
+		Name:   name,
+		Args:   make([]pluginValue, 0, len(args)),
+	}
+	var marks []cty.ValueMarks
+	for i, arg := range args {
+		arg, argMarks := arg.UnmarkDeep()
+		marks = append(marks, argMarks)
+		ty, err := ctyjson.MarshalType(arg.Type())
+		if err != nil {
+			return cty.UnknownVal(retType), function.NewArgError(i, err)
+		}
+		value, err := ctyjson.Marshal(arg, arg.Type())
+		if err != nil {
+			return cty.UnknownVal(retType), function.NewArgError(i, err)
+		}
+		req.Args = append(req.Args, pluginValue{Value: value, Type: ty})
+	}
+	var resp pluginCallResponse
+	if err := p.roundTrip(req, &resp); err != nil {
+		return cty.UnknownVal(retType), fmt.Errorf("plugin: %w", err)
+	}
+	if resp.Error != "" {
+		return cty.UnknownVal(retType), errors.New(resp.Error)
+	}
+	ty := retType
+	if len(resp.Type) > 0 {
+		var err error
+		ty, err = ctyjson.UnmarshalType(resp.Type)
+		if err != nil {
+			return cty.UnknownVal(retType), fmt.Errorf("plugin returns invalid type: %w", err)
+		}
+	}
+	if ty == cty.DynamicPseudoType {
+		return cty.UnknownVal(retType), errors.New("plugin must return the type of a dynamic value")
+	}
+	value, err := ctyjson.Unmarshal(resp.Value, ty)
+	if err != nil {
+		return cty.UnknownVal(retType), fmt.Errorf("plugin returns invalid value: %w", err)
+	}
+	value, err = convert.Convert(value, retType)
+	if err != nil {
+		return cty.UnknownVal(retType), fmt.Errorf("plugin returns %s, but %s is declared: %s", ty.FriendlyName(), retType.FriendlyName(), err)
+	}
+	return value.WithMarks(marks...), nil
+}
+
+// Functions returns the functions provided by the plugin.
+func (p *Plugin) Functions() map[string]function.Function {
+	functions := make(map[string]function.Function, len(p.functions))
+	for name, f := range p.functions {
+		functions[name] = f
+	}
+	return functions
+}
+
+// Close terminates the plugin process.
+// It closes stdin of the plugin, and kills the process if it does not exit in a second.
+// It does not wait for the running calls, which fail after Close.
+func (p *Plugin) Close() error {
+	closed := false
+	p.doneOnce.Do(func() {
+		closed = true
+		close(p.done)
+		p.stdin.Close()
+	})
+	timer := time.NewTimer(pluginCloseTimeout)
+	defer timer.Stop()
+	select {
+	case <-p.exited:
+	case <-timer.C:
+		p.cmd.Process.Kill()
+		<-p.exited
+		return nil
+	}
+	if !closed {
+		return nil
+	}
+	return p.waitErr
+}
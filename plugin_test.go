@@ -0,0 +1,143 @@
+package hclconfig_test
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mashiike/hclconfig"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func buildTestPlugin(t *testing.T) string {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "plugin")
+	output, err := exec.Command("go", "build", "-o", bin, "./testdata/plugin").CombinedOutput()
+	if err != nil {
+		t.Skipf("can not build test plugin: %s: %s", err, output)
+	}
+	return bin
+}
+
+func TestPlugin(t *testing.T) {
+	plugin, err := hclconfig.StartPlugin(buildTestPlugin(t))
+	require.NoError(t, err)
+	defer plugin.Close()
+
+	loader := hclconfig.New()
+	loader.Functions(plugin.Functions())
+	ctx := loader.NewEvalContext("testdata")
+	cases := []struct {
+		expr     string
+		expected cty.Value
+		err      string
+	}{
+		{
+			expr:     `lookup("hoge")`,
+			expected: cty.StringVal("fuga"),
+		},
+		{
+			expr: `lookup("tora")`,
+			err:  `expression.hcl:1,1-8: Error in function call; Call to function "lookup" failed: tora not found.`,
+		},
+		{
+			expr:     `join_all("-", "a", "b", upper("c"))`,
+			expected: cty.StringVal("a-b-C"),
+		},
+		{
+			expr: `echo({name = "hoge", ports = [8080, 8081]})`,
+			expected: cty.ObjectVal(map[string]cty.Value{
+				"name":  cty.StringVal("hoge"),
+				"ports": cty.TupleVal([]cty.Value{cty.NumberIntVal(8080), cty.NumberIntVal(8081)}),
+			}),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			expr, diags := hclsyntax.ParseExpression([]byte(c.expr), "expression.hcl", hcl.InitialPos)
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			value, diags := expr.Value(ctx)
+			if c.err != "" {
+				require.EqualError(t, diags, c.err)
+				return
+			}
+			if diags.HasErrors() {
+				require.FailNow(t, diags.Error())
+			}
+			require.True(t, c.expected.RawEquals(value), "expected %#v, actual %#v", c.expected, value)
+		})
+	}
+	require.NoError(t, plugin.Close())
+}
+
+func TestPluginWrongReturnType(t *testing.T) {
+	plugin, err := hclconfig.StartPlugin(buildTestPlugin(t))
+	require.NoError(t, err)
+	defer plugin.Close()
+
+	loader := hclconfig.New()
+	loader.Functions(plugin.Functions())
+	expr, diags := hclsyntax.ParseExpression([]byte(`wrong_type()`), "expression.hcl", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	_, diags = expr.Value(loader.NewEvalContext("testdata"))
+	require.EqualError(t, diags, `expression.hcl:1,1-12: Error in function call; Call to function "wrong_type" failed: plugin returns string, but number is declared: a number is required.`)
+}
+
+func TestPluginTimeout(t *testing.T) {
+	var stderr bytes.Buffer
+	plugin, err := hclconfig.StartPluginWithOptions(hclconfig.PluginOptions{
+		Timeout: 200 * time.Millisecond,
+		Stderr:  &stderr,
+	}, buildTestPlugin(t))
+	require.NoError(t, err)
+	defer plugin.Close()
+
+	loader := hclconfig.New()
+	loader.Functions(plugin.Functions())
+	ctx := loader.NewEvalContext("testdata")
+	expr, diags := hclsyntax.ParseExpression([]byte(`hang()`), "expression.hcl", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	start := time.Now()
+	_, diags = expr.Value(ctx)
+	require.EqualError(t, diags, `expression.hcl:1,1-6: Error in function call; Call to function "hang" failed: plugin: no response in 200ms, the plugin is killed.`)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	expr, diags = hclsyntax.ParseExpression([]byte(`lookup("hoge")`), "expression.hcl", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	_, diags = expr.Value(ctx)
+	require.EqualError(t, diags, `expression.hcl:1,1-8: Error in function call; Call to function "lookup" failed: plugin: plugin is closed.`)
+	require.NoError(t, plugin.Close())
+	require.Equal(t, "hanging\n", stderr.String())
+}
+
+func TestPluginCloseDuringCall(t *testing.T) {
+	plugin, err := hclconfig.StartPluginWithOptions(hclconfig.PluginOptions{
+		Stderr: io.Discard,
+	}, buildTestPlugin(t))
+	require.NoError(t, err)
+
+	loader := hclconfig.New()
+	loader.Functions(plugin.Functions())
+	ctx := loader.NewEvalContext("testdata")
+	expr, diags := hclsyntax.ParseExpression([]byte(`hang()`), "expression.hcl", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	called := make(chan hcl.Diagnostics)
+	go func() {
+		_, diags := expr.Value(ctx)
+		called <- diags
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, plugin.Close())
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.EqualError(t, <-called, `expression.hcl:1,1-6: Error in function call; Call to function "hang" failed: plugin: plugin is closed.`)
+}
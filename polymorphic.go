@@ -0,0 +1,177 @@
+package hclconfig
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+type blockTypeKey struct {
+	iface     reflect.Type
+	blockType string
+	label     string
+}
+
+var (
+	blockTypesMu sync.RWMutex
+	blockTypes   = map[blockTypeKey]func() interface{}{}
+)
+
+// RegisterBlockType registers the factory of the concrete type for the blocks of blockType with the first label.
+// The registered types are decoded into the Polymorphic[T] remain field of the block.
+// It panics if the same label is registered twice for the block type and T.
+func RegisterBlockType[T any](blockType, label string, factory func() T) {
+	if factory == nil {
+		panic("hclconfig: RegisterBlockType factory is nil")
+	}
+	key := blockTypeKey{
+		iface:     reflect.TypeOf((*T)(nil)).Elem(),
+		blockType: blockType,
+		label:     label,
+	}
+	blockTypesMu.Lock()
+	defer blockTypesMu.Unlock()
+	if _, ok := blockTypes[key]; ok {
+		panic(fmt.Sprintf("hclconfig: RegisterBlockType called twice for %s %q", blockType, label))
+	}
+	blockTypes[key] = func() interface{} {
+		return factory()
+	}
+}
+
+// registeredLabels returns the sorted labels registered for the block type and the interface.
+func registeredLabels(iface reflect.Type, blockType string) []string {
+	blockTypesMu.RLock()
+	defer blockTypesMu.RUnlock()
+	var labels []string
+	for key := range blockTypes {
+		if key.iface == iface && key.blockType == blockType {
+			labels = append(labels, key.label)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func lookupBlockType(iface reflect.Type, blockType, label string) (func() interface{}, bool) {
+	blockTypesMu.RLock()
+	defer blockTypesMu.RUnlock()
+	factory, ok := blockTypes[blockTypeKey{iface: iface, blockType: blockType, label: label}]
+	return factory, ok
+}
+
+// Polymorphic is a remain field decoded into the concrete type registered with RegisterBlockType.
+// The concrete type is chosen by the block type and the first label of the enclosing block.
+//
+//	type Payload struct {
+//		Type    string                         `hcl:"type,label"`
+//		Name    string                         `hcl:"name,label"`
+//		Payload hclconfig.Polymorphic[Handler] `hcl:",remain"`
+//	}
+type Polymorphic[T any] struct {
+	Value T
+}
+
+// Get returns the decoded value.
+func (p Polymorphic[T]) Get() T {
+	return p.Value
+}
+
+type polymorphic interface {
+	concreteValue(block *hcl.Block) (reflect.Value, *hcl.Diagnostic)
+	setValue(v reflect.Value)
+	value() reflect.Value
+}
+
+var polymorphicType = reflect.TypeOf((*polymorphic)(nil)).Elem()
+
+// concreteValue returns a new value of the concrete type registered for the block.
+func (p *Polymorphic[T]) concreteValue(block *hcl.Block) (reflect.Value, *hcl.Diagnostic) {
+	iface := reflect.TypeOf((*T)(nil)).Elem()
+	if block == nil || len(block.Labels) == 0 {
+		return reflect.Value{}, NewDiagnosticError(
+			"Unsupported polymorphic block",
+			fmt.Sprintf("Polymorphic[%s] must be the remain field of a labeled block.", iface.String()),
+			nil,
+		)
+	}
+	factory, ok := lookupBlockType(iface, block.Type, block.Labels[0])
+	if !ok {
+		detail := fmt.Sprintf("The %s type %q is not registered.", block.Type, block.Labels[0])
+		if labels := registeredLabels(iface, block.Type); len(labels) > 0 {
+			detail += fmt.Sprintf(" Valid types are %s.", quoteJoin(labels))
+		}
+		return reflect.Value{}, NewDiagnosticError(
+			fmt.Sprintf("Unknown %s type", block.Type),
+			detail,
+			block.LabelRanges[0].Ptr(),
+		)
+	}
+	return reflect.ValueOf(factory()), nil
+}
+
+func (p *Polymorphic[T]) setValue(v reflect.Value) {
+	p.Value = v.Interface().(T)
+}
+
+func (p *Polymorphic[T]) value() reflect.Value {
+	return reflect.ValueOf(&p.Value).Elem()
+}
+
+// asPolymorphic returns the field as polymorphic if it is a Polymorphic[T].
+func asPolymorphic(fieldV reflect.Value) (polymorphic, bool) {
+	if !fieldV.CanAddr() || !fieldV.Addr().Type().Implements(polymorphicType) {
+		return nil, false
+	}
+	return fieldV.Addr().Interface().(polymorphic), true
+}
+
+// decodePolymorphic decodes the remain body into the concrete type registered for the block.
+func decodePolymorphic(block *hcl.Block, body hcl.Body, ctx *hcl.EvalContext, p polymorphic) hcl.Diagnostics {
+	v, diag := p.concreteValue(block)
+	if diag != nil {
+		if diag.Subject == nil {
+			diag.Subject = body.MissingItemRange().Ptr()
+		}
+		return hcl.Diagnostics{diag}
+	}
+	if v.Kind() == reflect.Pointer {
+		diags := decodeBodyToValue(body, ctx, v.Elem())
+		p.setValue(v)
+		return diags
+	}
+	// the factory returned a non-pointer value, so the addressable copy is decoded.
+	target := reflect.New(v.Type()).Elem()
+	target.Set(v)
+	diags := decodeBodyToValue(body, ctx, target)
+	p.setValue(target)
+	return diags
+}
+
+// polymorphicConcreteType returns the concrete struct type registered for the block, if ty has a Polymorphic remain field.
+func polymorphicConcreteType(ty reflect.Type, block *hcl.Block) (reflect.Type, bool) {
+	tags := getFieldTags(ty)
+	if tags.Remain == nil {
+		return nil, false
+	}
+	fieldType := ty.FieldByIndex(tags.Remain).Type
+	if !reflect.PointerTo(fieldType).Implements(polymorphicType) {
+		return nil, false
+	}
+	p := reflect.New(fieldType).Interface().(polymorphic)
+	v, diag := p.concreteValue(block)
+	if diag != nil {
+		return nil, false
+	}
+	concrete := v.Type()
+	if concrete.Kind() == reflect.Pointer {
+		concrete = concrete.Elem()
+	}
+	if concrete.Kind() != reflect.Struct {
+		return nil, false
+	}
+	return concrete, true
+}
@@ -0,0 +1,209 @@
+package hclconfig
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Ref is a reference to a decoded block, written as a traversal such as `upstream = service.http.hoge`.
+// The reference is resolved after decoding, and dangling references are reported at the traversal range.
+// The blocks decoded into maps of non-pointer values are referred to as copies.
+type Ref[T any] struct {
+	traversal hcl.Traversal
+	target    *T
+}
+
+// DecodeExpression implements ExpressionDecoder. The expression must be a static traversal.
+func (r *Ref[T]) DecodeExpression(expr hcl.Expression, ctx *hcl.EvalContext) hcl.Diagnostics {
+	traversal, diags := hcl.AbsTraversalForExpr(expr)
+	if diags.HasErrors() {
+		return diags
+	}
+	if _, ok := traversalPath(traversal); !ok {
+		return append(diags, NewDiagnosticError(
+			"Invalid reference",
+			"A reference to a block such as service.http.hoge is required.",
+			traversal.SourceRange().Ptr(),
+		))
+	}
+	r.traversal = traversal
+	r.target = nil
+	return diags
+}
+
+// Get returns the referred block, or nil if the reference is not set.
+func (r Ref[T]) Get() *T {
+	return r.target
+}
+
+// Name returns the reference such as "service.http.hoge".
+func (r Ref[T]) Name() string {
+	path, _ := traversalPath(r.traversal)
+	return strings.Join(path, ".")
+}
+
+// Range returns the range of the traversal.
+func (r Ref[T]) Range() hcl.Range {
+	return r.traversal.SourceRange()
+}
+
+func (r *Ref[T]) resolve(blocks map[string]reflect.Value) *hcl.Diagnostic {
+	if r.traversal == nil {
+		return nil
+	}
+	name := r.Name()
+	target, ok := blocks[name]
+	if !ok {
+		return NewDiagnosticError(
+			"Reference to undeclared block",
+			fmt.Sprintf("A block %s has not been declared.", name),
+			r.traversal.SourceRange().Ptr(),
+		)
+	}
+	ptr, ok := target.Interface().(*T)
+	if !ok {
+		return NewDiagnosticError(
+			"Invalid reference",
+			fmt.Sprintf("%s is %s, not %s.", name, target.Type().Elem().String(), reflect.TypeOf((*T)(nil)).Elem().String()),
+			r.traversal.SourceRange().Ptr(),
+		)
+	}
+	r.target = ptr
+	return nil
+}
+
+type refResolver interface {
+	resolve(blocks map[string]reflect.Value) *hcl.Diagnostic
+}
+
+var refResolverType = reflect.TypeOf((*refResolver)(nil)).Elem()
+
+// traversalPath returns the names of the traversal steps, and reports whether the traversal consists of names and keys.
+func traversalPath(traversal hcl.Traversal) ([]string, bool) {
+	path := make([]string, 0, len(traversal))
+	for _, step := range traversal {
+		switch s := step.(type) {
+		case hcl.TraverseRoot:
+			path = append(path, s.Name)
+		case hcl.TraverseAttr:
+			path = append(path, s.Name)
+		case hcl.TraverseIndex:
+			if s.Key.Type() != cty.String || s.Key.IsNull() {
+				return nil, false
+			}
+			path = append(path, s.Key.AsString())
+		default:
+			return nil, false
+		}
+	}
+	return path, true
+}
+
+// resolveRefs resolves the Ref fields of the decoded value, to the blocks named in the same way as implied variables.
+func resolveRefs(val reflect.Value) hcl.Diagnostics {
+	if val.Kind() != reflect.Struct || !val.CanAddr() {
+		return nil
+	}
+	blocks := make(map[string]reflect.Value)
+	walkBlocks(val, nil, func(path []string, ptr reflect.Value) {
+		if len(path) > 0 {
+			blocks[strings.Join(path, ".")] = ptr
+		}
+	})
+	var diags hcl.Diagnostics
+	walkBlocks(val, nil, func(path []string, ptr reflect.Value) {
+		elem := ptr.Elem()
+		tags := getFieldTags(elem.Type())
+		for _, name := range sortedFieldNames(tags.Attributes) {
+			fieldV, ok := fieldByIndex(elem, tags.Attributes[name], false)
+			if !ok {
+				continue
+			}
+			if fieldV.Kind() != reflect.Pointer {
+				fieldV = fieldV.Addr()
+			}
+			if fieldV.IsNil() || !fieldV.Type().Implements(refResolverType) {
+				continue
+			}
+			if diag := fieldV.Interface().(refResolver).resolve(blocks); diag != nil {
+				diags = append(diags, diag)
+			}
+		}
+	})
+	return diags
+}
+
+// walkBlocks calls fn with the pointers to the struct and the decoded blocks, and their paths of the block types and labels.
+func walkBlocks(val reflect.Value, path []string, fn func(path []string, ptr reflect.Value)) {
+	fn(path, val.Addr())
+	tags := getFieldTags(val.Type())
+	for _, blockType := range sortedFieldNames(tags.Blocks) {
+		fieldV, ok := fieldByIndex(val, tags.Blocks[blockType], false)
+		if !ok {
+			continue
+		}
+		blockPath := append(append([]string{}, path...), blockType)
+		switch fieldV.Kind() {
+		case reflect.Slice:
+			for i := 0; i < fieldV.Len(); i++ {
+				walkBlockElem(fieldV.Index(i), blockPath, fn)
+			}
+		case reflect.Map:
+			walkBlockMap(fieldV, blockPath, fn)
+		default:
+			walkBlockElem(fieldV, blockPath, fn)
+		}
+	}
+}
+
+func walkBlockElem(v reflect.Value, path []string, fn func(path []string, ptr reflect.Value)) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	walkBlocks(v, append(append([]string{}, path...), getLabelValues(v)...), fn)
+}
+
+func walkBlockMap(m reflect.Value, path []string, fn func(path []string, ptr reflect.Value)) {
+	for _, key := range m.MapKeys() {
+		elemPath := append(append([]string{}, path...), key.String())
+		elem := m.MapIndex(key)
+		switch {
+		case elem.Kind() == reflect.Map:
+			walkBlockMap(elem, elemPath, fn)
+		case elem.Kind() == reflect.Pointer:
+			if !elem.IsNil() && elem.Elem().Kind() == reflect.Struct {
+				walkBlocks(elem.Elem(), elemPath, fn)
+			}
+		case elem.Kind() == reflect.Struct:
+			// map elements are not addressable, so the copy is walked and stored back.
+			v := reflect.New(elem.Type()).Elem()
+			v.Set(elem)
+			walkBlocks(v, elemPath, fn)
+			m.SetMapIndex(key, v)
+		}
+	}
+}
+
+// getLabelValues returns the values of the label fields.
+func getLabelValues(val reflect.Value) []string {
+	tags := getFieldTags(val.Type())
+	labels := make([]string, 0, len(tags.Labels))
+	for _, label := range tags.Labels {
+		labelV, ok := fieldByIndex(val, label.FieldIndex, false)
+		if !ok {
+			return labels
+		}
+		labels = append(labels, labelV.String())
+	}
+	return labels
+}
@@ -0,0 +1,257 @@
+package hclconfig
+
+import (
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// LoadReport is the record of what a load depended on.
+// It is recorded by the functions while they are called, so the calls which are not evaluated are not included.
+// The ranges of the calls are found by the function names and the literal arguments,
+// and they are empty if the calls are ambiguous or in *.hcl.json files.
+type LoadReport struct {
+	// Envs is the environment variables read by the env and must_env functions.
+	Envs []EnvReference
+	// Files is the files opened by the file, templatefile and template_include functions.
+	Files []FileReference
+	// Functions is the all function calls.
+	Functions []FunctionCall
+}
+
+// EnvReference is an environment variable read by a function call.
+type EnvReference struct {
+	Name string
+	// Set is false when the environment variable is not set, and the default value is used.
+	Set   bool
+	Range hcl.Range
+}
+
+// FileReference is a file opened by a function call.
+type FileReference struct {
+	// Path is the resolved path of the file.
+	Path  string
+	Range hcl.Range
+}
+
+// FunctionCall is a function call in the configuration.
+type FunctionCall struct {
+	Name  string
+	Range hcl.Range
+}
+
+// loadRecorder records the LoadReport of a load. The methods do nothing on a nil recorder.
+type loadRecorder struct {
+	report LoadReport
+	seen   map[interface{}]bool
+
+	// sites is the function calls in the configuration by the function names,
+	// results is the last results of the calls by the ranges, and current is the ranges of the running call.
+	sites   map[string][]callSite
+	known   map[hcl.Range]bool
+	results map[hcl.Range]cty.Value
+	current []hcl.Range
+}
+
+// callSite is a function call in the configuration.
+// args is the values of the arguments which can be evaluated without variables and functions, or cty.NilVal.
+// calls is the ranges of the arguments which are function calls.
+type callSite struct {
+	rng    hcl.Range
+	args   []cty.Value
+	calls  []*hcl.Range
+	expand bool
+}
+
+func newLoadRecorder() *loadRecorder {
+	return &loadRecorder{
+		seen:    make(map[interface{}]bool),
+		sites:   make(map[string][]callSite),
+		known:   make(map[hcl.Range]bool),
+		results: make(map[hcl.Range]cty.Value),
+	}
+}
+
+// collect collects the function calls in node, so that the recorded calls are attributed to them.
+func (r *loadRecorder) collect(node hclsyntax.Node) {
+	if r == nil {
+		return
+	}
+	hclsyntax.VisitAll(node, func(node hclsyntax.Node) hcl.Diagnostics {
+		call, ok := node.(*hclsyntax.FunctionCallExpr)
+		if !ok || r.known[call.Range()] {
+			return nil
+		}
+		r.known[call.Range()] = true
+		site := callSite{
+			rng:    call.Range(),
+			args:   make([]cty.Value, len(call.Args)),
+			calls:  make([]*hcl.Range, len(call.Args)),
+			expand: call.ExpandFinal,
+		}
+		for i, arg := range call.Args {
+			if value, diags := arg.Value(nil); !diags.HasErrors() && value.IsWhollyKnown() {
+				site.args[i] = value
+			}
+			if argCall, ok := arg.(*hclsyntax.FunctionCallExpr); ok {
+				site.calls[i] = argCall.Range().Ptr()
+			}
+		}
+		r.sites[call.Name] = append(r.sites[call.Name], site)
+		return nil
+	})
+}
+
+// callRanges returns the ranges of the calls with the arguments. Functions are not given their call ranges,
+// so the calls are found by the names and the arguments written as literals or the results of the calls.
+// The calls with the same arguments are all returned, and an empty range is returned if the call is not found or ambiguous.
+func (r *loadRecorder) callRanges(name string, args []cty.Value) []hcl.Range {
+	var exact, matched []hcl.Range
+	for _, site := range r.sites[name] {
+		static, ok := r.match(site, args)
+		if !ok {
+			continue
+		}
+		matched = append(matched, site.rng)
+		if static {
+			exact = append(exact, site.rng)
+		}
+	}
+	switch {
+	case len(exact) > 0:
+		return exact
+	case len(matched) == 1:
+		return matched
+	default:
+		return []hcl.Range{{}}
+	}
+}
+
+// match reports whether the call can have the arguments, and whether all of them are known.
+func (r *loadRecorder) match(site callSite, args []cty.Value) (bool, bool) {
+	if site.expand {
+		return false, true
+	}
+	if len(site.args) != len(args) {
+		return false, false
+	}
+	static := true
+	for i, expected := range site.args {
+		if expected == cty.NilVal && site.calls[i] != nil {
+			expected = r.results[*site.calls[i]]
+		}
+		if expected == cty.NilVal {
+			static = false
+			continue
+		}
+		actual, _ := args[i].UnmarkDeep()
+		converted, err := convert.Convert(expected, actual.Type())
+		if err != nil || !converted.RawEquals(actual) {
+			return false, false
+		}
+	}
+	return static, true
+}
+
+// ranges returns the ranges of the running call, or an empty range if it is unknown.
+func (r *loadRecorder) ranges() []hcl.Range {
+	if len(r.current) == 0 {
+		return []hcl.Range{{}}
+	}
+	return r.current
+}
+
+// add reports whether the reference is new. The same reference is recorded once,
+// even if the expression is evaluated more than once.
+func (r *loadRecorder) add(ref interface{}) bool {
+	if r.seen[ref] {
+		return false
+	}
+	r.seen[ref] = true
+	return true
+}
+
+func (r *loadRecorder) env(name string, set bool) {
+	if r == nil {
+		return
+	}
+	for _, rng := range r.ranges() {
+		ref := EnvReference{Name: name, Set: set, Range: rng}
+		if r.add(ref) {
+			r.report.Envs = append(r.report.Envs, ref)
+		}
+	}
+}
+
+func (r *loadRecorder) file(path string) {
+	if r == nil {
+		return
+	}
+	for _, rng := range r.ranges() {
+		ref := FileReference{Path: path, Range: rng}
+		if r.add(ref) {
+			r.report.Files = append(r.report.Files, ref)
+		}
+	}
+}
+
+func (r *loadRecorder) call(name string, ranges []hcl.Range) {
+	for _, rng := range ranges {
+		ref := FunctionCall{Name: name, Range: rng}
+		if r.add(ref) {
+			r.report.Functions = append(r.report.Functions, ref)
+		}
+	}
+}
+
+// finish returns the report sorted by the ranges.
+func (r *loadRecorder) finish() *LoadReport {
+	report := r.report
+	sortByRange(report.Envs, func(i int) hcl.Range { return report.Envs[i].Range })
+	sortByRange(report.Files, func(i int) hcl.Range { return report.Files[i].Range })
+	sortByRange(report.Functions, func(i int) hcl.Range { return report.Functions[i].Range })
+	return &report
+}
+
+func sortByRange(slice interface{}, rangeOf func(int) hcl.Range) {
+	sort.SliceStable(slice, func(i, j int) bool {
+		ri, rj := rangeOf(i), rangeOf(j)
+		if ri.Filename != rj.Filename {
+			return ri.Filename < rj.Filename
+		}
+		return ri.Start.Byte < rj.Start.Byte
+	})
+}
+
+// recordCalls wraps the function to record its calls.
+func (r *loadRecorder) recordCalls(name string, f function.Function) function.Function {
+	if r == nil {
+		return f
+	}
+	return function.New(&function.Spec{
+		Description: f.Description(),
+		Params:      f.Params(),
+		VarParam:    f.VarParam(),
+		Type:        f.ReturnTypeForValues,
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			ranges := r.callRanges(name, args)
+			r.call(name, ranges)
+			outer := r.current
+			r.current = ranges
+			defer func() {
+				r.current = outer
+			}()
+			value, err := f.Call(args)
+			if err == nil && value.IsWhollyKnown() {
+				for _, rng := range ranges {
+					r.results[rng], _ = value.UnmarkDeep()
+				}
+			}
+			return value, err
+		},
+	})
+}
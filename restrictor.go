@@ -3,10 +3,14 @@ package hclconfig
 import (
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/hashicorp/hcl/v2"
-	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 )
 
 type Restrictor interface {
@@ -16,34 +20,26 @@ type Restrictor interface {
 var victimRestrictor Restrictor
 var restrictorType = reflect.TypeOf(&victimRestrictor).Elem()
 
-func asRestrictor(val reflect.Value) (Restrictor, bool) {
-	ty := val.Type()
-	if ty.Implements(restrictorType) {
-		restrictor, ok := val.Interface().(Restrictor)
-		return restrictor, ok
-	}
-	if ty.Kind() == reflect.Pointer {
-		return asRestrictor(val.Elem())
-	}
-	valAddr := val.Addr()
-	if valAddr.Type().Implements(restrictorType) {
-		restrictor, ok := valAddr.Interface().(Restrictor)
-		return restrictor, ok
-	}
-	return nil, false
-}
-
 func restrict(body hcl.Body, ctx *hcl.EvalContext, val interface{}) hcl.Diagnostics {
 	rv := reflect.ValueOf(val)
 	return restrictImpl(body, ctx, rv)
 }
 
+// restrictImpl restricts the value decoded from the body.
+// The maps are decoded from the attributes of the body, and the blocks in slices are restricted by restrictStruct with their own bodies.
 func restrictImpl(body hcl.Body, ctx *hcl.EvalContext, val reflect.Value) hcl.Diagnostics {
 	et := val.Type()
 	switch et.Kind() {
-	case reflect.Map, reflect.Slice, reflect.Array:
-		return nil
-	case reflect.Pointer:
+	case reflect.Map:
+		attrs, diags := body.JustAttributes()
+		if diags.HasErrors() {
+			return nil
+		}
+		return restrictAttributes(attrs, ctx, val)
+	case reflect.Pointer, reflect.Interface:
+		if val.IsNil() {
+			return nil
+		}
 		return restrictImpl(body, ctx, val.Elem())
 	case reflect.Struct:
 		return restrictStruct(body, ctx, val)
@@ -52,67 +48,173 @@ func restrictImpl(body hcl.Body, ctx *hcl.EvalContext, val reflect.Value) hcl.Di
 	}
 }
 
+// restrictAttributes restricts the elements of the map decoded from the attributes.
+// The elements have no body, so Restrict is called with the content of the attribute range.
+func restrictAttributes(attrs hcl.Attributes, ctx *hcl.EvalContext, val reflect.Value) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if val.IsNil() {
+		return diags
+	}
+	for _, key := range val.MapKeys() {
+		attr, ok := attrs[key.String()]
+		if !ok {
+			continue
+		}
+		content := &hcl.BodyContent{
+			Attributes:       hcl.Attributes{},
+			MissingItemRange: attr.Expr.Range(),
+		}
+		// map elements are not addressable, so the copy is restricted and stored back.
+		v := reflect.New(val.Type().Elem()).Elem()
+		v.Set(val.MapIndex(key))
+		diags = append(diags, restrictValue(content, ctx, v)...)
+		val.SetMapIndex(key, v)
+	}
+	return diags
+}
+
+// restrictValue calls Restrict of the value decoded from an expression, and of its elements.
+func restrictValue(content *hcl.BodyContent, ctx *hcl.EvalContext, val reflect.Value) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	switch val.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if val.IsNil() {
+			return diags
+		}
+		if val.Kind() == reflect.Interface && val.Elem().Kind() != reflect.Pointer {
+			return diags
+		}
+		return restrictValue(content, ctx, val.Elem())
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			diags = append(diags, restrictValue(content, ctx, val.Index(i))...)
+		}
+	case reflect.Map:
+		for _, key := range val.MapKeys() {
+			v := reflect.New(val.Type().Elem()).Elem()
+			v.Set(val.MapIndex(key))
+			diags = append(diags, restrictValue(content, ctx, v)...)
+			val.SetMapIndex(key, v)
+		}
+	case reflect.Struct:
+		for _, restrictor := range implementations(val, restrictorType) {
+			diags = append(diags, restrictor.(Restrictor).Restrict(content, ctx)...)
+		}
+	}
+	return diags
+}
+
 func restrictStruct(body hcl.Body, ctx *hcl.EvalContext, val reflect.Value) hcl.Diagnostics {
 	var diags hcl.Diagnostics
-	schema, partial := gohcl.ImpliedBodySchema(val.Interface())
+	schema, partial := impliedBodySchema(val.Interface())
 	var content *hcl.BodyContent
+	var leftovers hcl.Body
 	var contntDiags hcl.Diagnostics
 	if partial {
-		content, _, contntDiags = body.PartialContent(schema)
+		content, leftovers, contntDiags = body.PartialContent(schema)
 	} else {
 		content, contntDiags = body.Content(schema)
 	}
 	diags = append(diags, contntDiags...)
-	restrictor, ok := asRestrictor(val)
-	if ok {
-		diags = append(diags, restrictor.Restrict(content, ctx)...)
+	for _, restrictor := range implementations(val, restrictorType) {
+		diags = append(diags, restrictor.(Restrictor).Restrict(content, ctx)...)
 	}
 
 	if content == nil {
 		return diags
 	}
+	diags = append(diags, validateStruct(content, val)...)
 
-	ty := val.Type()
-	numField := ty.NumField()
-	for i := 0; i < numField; i++ {
-		field := ty.Field(i)
-		tag := field.Tag.Get("hcl")
-		if tag == "" {
+	tags := getFieldTags(val.Type())
+	if tags.Remain != nil && leftovers != nil {
+		diags = append(diags, restrictRemain(leftovers, ctx, val, tags.Remain)...)
+	}
+	// the blocks are decoded in the order of appearance, so the values are paired with the blocks by position.
+	blocksByType := content.Blocks.ByType()
+	for _, tagName := range sortedFieldNames(tags.Blocks) {
+		index := tags.Blocks[tagName]
+		field := val.Type().FieldByIndex(index)
+		fieldValue, ok := fieldByIndex(val, index, false)
+		if !ok {
 			continue
 		}
-		tagName, kind := getHCLTagNameKind(tag)
-		if tagName == "" {
+		blocks := blocksByType[tagName]
+		if field.Type.Kind() == reflect.Map {
+			diags = append(diags, restrictMap(blocks, tagName, ctx, fieldValue)...)
 			continue
 		}
-		if kind != "block" {
+		if len(blocks) == 0 {
 			continue
 		}
-		fieldValue := val.Field(i)
 		if field.Type.Kind() == reflect.Slice {
-			numSlice := fieldValue.Len()
-			for j := 0; j < numSlice; j++ {
-				v := fieldValue.Index(j)
-				labels := getLabels(v)
-				k, block := getBlock(content.Blocks, tagName, labels)
-				if k == -1 {
-					break
-				}
-				restrictDiags := restrictImpl(block.Body, ctx, v)
-				diags = append(diags, restrictDiags...)
+			for j := 0; j < fieldValue.Len() && j < len(blocks); j++ {
+				diags = append(diags, restrictImpl(blocks[j].Body, ctx, fieldValue.Index(j))...)
 			}
 			continue
 		}
-		labels := getLabels(fieldValue)
-		k, block := getBlock(content.Blocks, tagName, labels)
-		if k == -1 {
+		diags = append(diags, restrictImpl(blocks[0].Body, ctx, fieldValue)...)
+	}
+	return diags
+}
+
+// restrictRemain restricts the remain field decoded from the leftovers.
+func restrictRemain(leftovers hcl.Body, ctx *hcl.EvalContext, val reflect.Value, index []int) hcl.Diagnostics {
+	fieldValue, ok := fieldByIndex(val, index, false)
+	if !ok {
+		return nil
+	}
+	if p, ok := asPolymorphic(fieldValue); ok {
+		return restrictImpl(leftovers, ctx, p.value())
+	}
+	fieldType := fieldValue.Type()
+	if assignableTo(bodyType, fieldType) || assignableTo(attrsType, fieldType) {
+		return nil
+	}
+	return restrictImpl(leftovers, ctx, fieldValue)
+}
+
+// restrictMap restricts the blocks decoded into the nested maps keyed by the labels.
+func restrictMap(blocks hcl.Blocks, tagName string, ctx *hcl.EvalContext, val reflect.Value) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	restricted := make(map[string]bool, len(blocks))
+	for _, block := range blocks {
+		key := strings.Join(block.Labels, ".")
+		if block.Type != tagName || restricted[key] {
+			continue
+		}
+		restricted[key] = true
+		m, leaf, ok := mapElemByLabels(val, block.Labels)
+		if !ok {
 			continue
 		}
-		restrictDiags := restrictImpl(block.Body, ctx, fieldValue)
-		diags = append(diags, restrictDiags...)
+		// map elements are not addressable, so the copy is restricted and stored back.
+		v := reflect.New(m.Type().Elem()).Elem()
+		v.Set(m.MapIndex(leaf))
+		diags = append(diags, restrictImpl(block.Body, ctx, v)...)
+		m.SetMapIndex(leaf, v)
 	}
 	return diags
 }
 
+// mapElemByLabels returns the innermost map of the nested maps keyed by the labels, and the key of the element.
+func mapElemByLabels(val reflect.Value, labels []string) (reflect.Value, reflect.Value, bool) {
+	if len(labels) == 0 || val.Kind() != reflect.Map || val.IsNil() {
+		return reflect.Value{}, reflect.Value{}, false
+	}
+	current := val
+	for _, label := range labels[:len(labels)-1] {
+		current = current.MapIndex(reflect.ValueOf(label).Convert(current.Type().Key()))
+		if !current.IsValid() || current.Kind() != reflect.Map {
+			return reflect.Value{}, reflect.Value{}, false
+		}
+	}
+	leaf := reflect.ValueOf(labels[len(labels)-1]).Convert(current.Type().Key())
+	if !current.MapIndex(leaf).IsValid() {
+		return reflect.Value{}, reflect.Value{}, false
+	}
+	return current, leaf, true
+}
+
 // RestrictUniqueBlockLabels implements the restriction that labels for each Block be unique.
 func RestrictUniqueBlockLabels(content *hcl.BodyContent, blockTypes ...string) hcl.Diagnostics {
 	var diags hcl.Diagnostics
@@ -137,30 +239,7 @@ func RestrictUniqueBlockLabels(content *hcl.BodyContent, blockTypes ...string) h
 		}
 		labels := strings.Join(block.Labels, ".")
 		if r, ok := ranges[labels]; ok {
-			parts := strings.Split(labels, ".")
-			switch len(parts) {
-			case 1:
-				diags = append(diags, &hcl.Diagnostic{
-					Severity: hcl.DiagError,
-					Summary:  fmt.Sprintf(`Duplicate %s declaration`, block.Type),
-					Detail:   fmt.Sprintf(`A %s named "%s" was already declared at %s. %s names must unique within a configuration`, block.Type, parts[0], r.String(), block.Type),
-					Subject:  block.DefRange.Ptr(),
-				})
-			case 2:
-				diags = append(diags, &hcl.Diagnostic{
-					Severity: hcl.DiagError,
-					Summary:  fmt.Sprintf(`Duplicate %s "%s" configuration`, block.Type, parts[0]),
-					Detail:   fmt.Sprintf(`A %s %s named "%s" was already declared at %s. %s names must unique per type in a configuration`, parts[0], block.Type, parts[1], r.String(), block.Type),
-					Subject:  block.DefRange.Ptr(),
-				})
-			default:
-				diags = append(diags, &hcl.Diagnostic{
-					Severity: hcl.DiagError,
-					Summary:  fmt.Sprintf(`Duplicate %s "%s" configuration`, block.Type, labels),
-					Detail:   fmt.Sprintf(`A %s named "%s" was already declared at %s. %s names must unique per labels`, block.Type, labels, r.String(), block.Type),
-					Subject:  block.DefRange.Ptr(),
-				})
-			}
+			diags = append(diags, duplicateBlockDiagnostic(block, r))
 		} else {
 			ranges[labels] = block.DefRange.Ptr()
 		}
@@ -169,6 +248,33 @@ func RestrictUniqueBlockLabels(content *hcl.BodyContent, blockTypes ...string) h
 	return diags
 }
 
+func duplicateBlockDiagnostic(block *hcl.Block, r *hcl.Range) *hcl.Diagnostic {
+	labels := strings.Join(block.Labels, ".")
+	switch len(block.Labels) {
+	case 1:
+		return &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf(`Duplicate %s declaration`, block.Type),
+			Detail:   fmt.Sprintf(`A %s named "%s" was already declared at %s. %s names must unique within a configuration`, block.Type, block.Labels[0], r.String(), block.Type),
+			Subject:  block.DefRange.Ptr(),
+		}
+	case 2:
+		return &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf(`Duplicate %s "%s" configuration`, block.Type, block.Labels[0]),
+			Detail:   fmt.Sprintf(`A %s %s named "%s" was already declared at %s. %s names must unique per type in a configuration`, block.Labels[0], block.Type, block.Labels[1], r.String(), block.Type),
+			Subject:  block.DefRange.Ptr(),
+		}
+	default:
+		return &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf(`Duplicate %s "%s" configuration`, block.Type, labels),
+			Detail:   fmt.Sprintf(`A %s named "%s" was already declared at %s. %s names must unique per labels`, block.Type, labels, r.String(), block.Type),
+			Subject:  block.DefRange.Ptr(),
+		}
+	}
+}
+
 // RestrictOnlyOneBlock implements the restriction that unique block per block type
 func RestrictOnlyOneBlock(content *hcl.BodyContent, blockTypes ...string) hcl.Diagnostics {
 	var diags hcl.Diagnostics
@@ -225,3 +331,211 @@ func RestrictRequiredBlock(content *hcl.BodyContent, blockTypes ...string) hcl.D
 	}
 	return diags
 }
+
+// RestrictOneOf implements the restriction that the attribute value be one of the values.
+// The values depending on variables or functions are not restricted, as well as the omitted or null values.
+func RestrictOneOf(content *hcl.BodyContent, name string, values ...string) hcl.Diagnostics {
+	attr, value, diags := restrictedAttributeValue(content, name, cty.String)
+	if attr == nil || diags.HasErrors() {
+		return diags
+	}
+	str := value.AsString()
+	for _, v := range values {
+		if str == v {
+			return diags
+		}
+	}
+	diags = append(diags, NewDiagnosticError(
+		fmt.Sprintf("Invalid %s", name),
+		fmt.Sprintf("%s must be one of %s, not %q", name, quoteJoin(values), str),
+		attr.Expr.Range().Ptr(),
+	))
+	return diags
+}
+
+// RestrictMutuallyExclusive implements the restriction that only one of the attributes be set.
+func RestrictMutuallyExclusive(content *hcl.BodyContent, names ...string) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	var first *hcl.Attribute
+	for _, attr := range attributesInOrder(content, names) {
+		if first == nil {
+			first = attr
+			continue
+		}
+		diags = append(diags, NewDiagnosticError(
+			"Conflicting attributes",
+			fmt.Sprintf("Only one of %s can be set. %q conflicts with %q defined at %s", quoteJoin(names), attr.Name, first.Name, first.Range.String()),
+			attr.Range.Ptr(),
+		))
+	}
+	return diags
+}
+
+// RestrictRequiredWith implements the restriction that the required attributes be set when the attribute is set.
+func RestrictRequiredWith(content *hcl.BodyContent, name string, required ...string) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	attr, ok := content.Attributes[name]
+	if !ok {
+		return diags
+	}
+	for _, r := range required {
+		if _, ok := content.Attributes[r]; ok {
+			continue
+		}
+		diags = append(diags, NewDiagnosticError(
+			"Missing required attribute",
+			fmt.Sprintf("%q is required when %q is set.", r, name),
+			attr.Range.Ptr(),
+		))
+	}
+	return diags
+}
+
+// RestrictAtLeastOneOf implements the restriction that at least one of the attributes be set.
+func RestrictAtLeastOneOf(content *hcl.BodyContent, names ...string) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if len(attributesInOrder(content, names)) > 0 {
+		return diags
+	}
+	diags = append(diags, NewDiagnosticError(
+		"Missing required attribute",
+		fmt.Sprintf("At least one of %s is required.", quoteJoin(names)),
+		content.MissingItemRange.Ptr(),
+	))
+	return diags
+}
+
+// RestrictBlockCount implements the restriction that the number of blocks be between min and max.
+// If max is negative, the number of blocks is not limited.
+func RestrictBlockCount(content *hcl.BodyContent, blockType string, min, max int) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	blocks := content.Blocks.OfType(blockType)
+	if len(blocks) < min {
+		diags = append(diags, NewDiagnosticError(
+			fmt.Sprintf(`Too few "%s" blocks`, blockType),
+			fmt.Sprintf(`At least %d "%s" blocks are required, but %d were defined.`, min, blockType, len(blocks)),
+			content.MissingItemRange.Ptr(),
+		))
+	}
+	if max >= 0 && len(blocks) > max {
+		diags = append(diags, NewDiagnosticError(
+			fmt.Sprintf(`Too many "%s" blocks`, blockType),
+			fmt.Sprintf(`At most %d "%s" blocks are allowed, but %d were defined.`, max, blockType, len(blocks)),
+			blocks[max].DefRange.Ptr(),
+		))
+	}
+	return diags
+}
+
+// RestrictLabelPattern implements the restriction that the labels of the blocks match the pattern.
+func RestrictLabelPattern(content *hcl.BodyContent, pattern *regexp.Regexp, blockTypes ...string) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	for _, block := range content.Blocks {
+		contains := false
+		for _, blockType := range blockTypes {
+			if block.Type == blockType || block.Type == "*" {
+				contains = true
+				break
+			}
+		}
+		if !contains {
+			continue
+		}
+		for i, label := range block.Labels {
+			if pattern.MatchString(label) {
+				continue
+			}
+			diags = append(diags, NewDiagnosticError(
+				fmt.Sprintf(`Invalid "%s" block label`, block.Type),
+				fmt.Sprintf(`The label %q must match %s.`, label, pattern.String()),
+				block.LabelRanges[i].Ptr(),
+			))
+		}
+	}
+	return diags
+}
+
+// RestrictAttributeType implements the restriction that the attribute value be convertible to the type.
+// The values depending on variables or functions are not restricted, as well as the omitted or null values.
+func RestrictAttributeType(content *hcl.BodyContent, name string, ty cty.Type) hcl.Diagnostics {
+	_, _, diags := restrictedAttributeValue(content, name, ty)
+	return diags
+}
+
+// RestrictNumberRange implements the restriction that the attribute value be a number between min and max.
+// The values depending on variables or functions are not restricted, as well as the omitted or null values.
+func RestrictNumberRange(content *hcl.BodyContent, name string, min, max float64) hcl.Diagnostics {
+	attr, value, diags := restrictedAttributeValue(content, name, cty.Number)
+	if attr == nil || diags.HasErrors() {
+		return diags
+	}
+	actual, _ := value.AsBigFloat().Float64()
+	var detail string
+	switch {
+	case actual < min:
+		detail = fmt.Sprintf("%s must be at least %s, not %s", name, formatFloat(min), formatFloat(actual))
+	case actual > max:
+		detail = fmt.Sprintf("%s must be at most %s, not %s", name, formatFloat(max), formatFloat(actual))
+	default:
+		return diags
+	}
+	diags = append(diags, NewDiagnosticError(
+		fmt.Sprintf("Invalid %s", name),
+		detail,
+		attr.Expr.Range().Ptr(),
+	))
+	return diags
+}
+
+// restrictedAttributeValue evaluates the attribute without variables and functions, and converts the value to the type.
+// The returned attribute is nil if the attribute is omitted, the value is null, or it can not be evaluated statically.
+func restrictedAttributeValue(content *hcl.BodyContent, name string, ty cty.Type) (*hcl.Attribute, cty.Value, hcl.Diagnostics) {
+	attr, ok := content.Attributes[name]
+	if !ok {
+		return nil, cty.NilVal, nil
+	}
+	value, diags := attr.Expr.Value(nil)
+	if diags.HasErrors() {
+		return nil, cty.NilVal, nil
+	}
+	value, _ = value.UnmarkDeep()
+	if value.IsNull() || !value.IsWhollyKnown() {
+		return nil, cty.NilVal, diags
+	}
+	converted, err := convert.Convert(value, ty)
+	if err != nil {
+		diags = append(diags, NewDiagnosticError(
+			"Incorrect attribute value type",
+			fmt.Sprintf("Inappropriate value for attribute %q: %s.", name, err.Error()),
+			attr.Expr.Range().Ptr(),
+		))
+		return attr, cty.NilVal, diags
+	}
+	return attr, converted, diags
+}
+
+// attributesInOrder returns the attributes of the names set in the content, in the order of the source.
+func attributesInOrder(content *hcl.BodyContent, names []string) []*hcl.Attribute {
+	var attrs []*hcl.Attribute
+	for _, name := range names {
+		if attr, ok := content.Attributes[name]; ok {
+			attrs = append(attrs, attr)
+		}
+	}
+	sort.SliceStable(attrs, func(i, j int) bool {
+		return attrs[i].Range.Start.Byte < attrs[j].Range.Start.Byte
+	})
+	return attrs
+}
+
+func quoteJoin(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -2,12 +2,16 @@ package hclconfig_test
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/mashiike/hclconfig"
 	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func TestRestrictRequiredBlock(t *testing.T) {
@@ -176,3 +180,346 @@ func TestRestrictUniqueBlockLabels(t *testing.T) {
 	}
 
 }
+
+type ValidateConfig struct {
+	IOMode   string          `hcl:"io_mode,optional" validate:"required,oneof=readonly readwrite"`
+	Name     string          `hcl:"name,optional" validate:"len=4,regexp=^[a-z]{2,}$"`
+	Endpoint string          `hcl:"endpoint,optional" validate:"url"`
+	Listen   string          `hcl:"listen,optional" validate:"hostport"`
+	Tags     []string        `hcl:"tags,optional" validate:"unique,max=3"`
+	Services []ValidateBlock `hcl:"service,block"`
+}
+
+type ValidateBlock struct {
+	Name    string        `hcl:"name,label"`
+	Port    int           `hcl:"port" validate:"min=1,max=65535"`
+	Timeout time.Duration `hcl:"timeout,optional" validate:"max=1m"`
+}
+
+func TestValidateTags(t *testing.T) {
+	cases := []struct {
+		src      string
+		expected []string
+	}{
+		{
+			src: `
+io_mode  = "readonly"
+name     = "hoge"
+endpoint = "https://example.com"
+listen   = "127.0.0.1:8080"
+tags     = ["a", "b"]
+service "http" {
+  port    = 8080
+  timeout = "30s"
+}`,
+			expected: []string{},
+		},
+		{
+			src: `io_mode = "append"`,
+			expected: []string{
+				`config.hcl:1,1-19: Invalid io_mode; io_mode must be one of readonly, readwrite, not "append"`,
+			},
+		},
+		{
+			src: `name = "tora"`,
+			expected: []string{
+				`config.hcl:1,1-1: Invalid io_mode; io_mode is required`,
+			},
+		},
+		{
+			src: `
+io_mode = "readonly"
+name    = "Hoge"`,
+			expected: []string{
+				`config.hcl:3,1-17: Invalid name; name must match ^[a-z]{2,}$, not "Hoge"`,
+			},
+		},
+		{
+			src: `
+io_mode  = "readonly"
+endpoint = "example.com"
+listen   = "127.0.0.1"`,
+			expected: []string{
+				`config.hcl:3,1-25: Invalid endpoint; endpoint must be an absolute URL, not "example.com"`,
+				`config.hcl:4,1-23: Invalid listen; listen must be a host:port, not "127.0.0.1": address 127.0.0.1: missing port in address`,
+			},
+		},
+		{
+			src: `
+io_mode = "readonly"
+tags    = ["a", "b", "a"]`,
+			expected: []string{
+				`config.hcl:3,1-26: Invalid tags; tags must have unique elements, a is duplicated`,
+			},
+		},
+		{
+			src: `
+io_mode = "readonly"
+service "http" {
+  port    = 0
+  timeout = "2m"
+}`,
+			expected: []string{
+				`config.hcl:4,3-14: Invalid port; port must be at least 1, not 0`,
+				`config.hcl:5,3-17: Invalid timeout; timeout must be at most 1m0s, not 2m0s`,
+			},
+		},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case.%d", i), func(t *testing.T) {
+			file, diags := hclsyntax.ParseConfig([]byte(c.src), "config.hcl", hcl.InitialPos)
+			require.False(t, diags.HasErrors(), diags.Error())
+			var cfg ValidateConfig
+			diags = hclconfig.DecodeBody(file.Body, hclconfig.NewEvalContext(), &cfg)
+			actual := make([]string, 0, len(diags))
+			for _, diag := range diags {
+				actual = append(actual, diag.Error())
+			}
+			require.EqualValues(t, c.expected, actual)
+		})
+	}
+}
+
+type RepeatedConfig struct {
+	Rules   []RepeatedRule    `hcl:"rule,block"`
+	Targets []*RepeatedTarget `hcl:"target,block"`
+}
+
+type RepeatedRule struct {
+	Action string `hcl:"action"`
+
+	Range string
+}
+
+func (r *RepeatedRule) Restrict(content *hcl.BodyContent, ctx *hcl.EvalContext) hcl.Diagnostics {
+	r.Range = hclconfig.AttributeRange(content, "action").String()
+	return nil
+}
+
+type RepeatedTarget struct {
+	Name string `hcl:"name,label"`
+	Port int    `hcl:"port"`
+
+	Range string
+}
+
+func (r *RepeatedTarget) Restrict(content *hcl.BodyContent, ctx *hcl.EvalContext) hcl.Diagnostics {
+	r.Range = hclconfig.AttributeRange(content, "port").String()
+	return nil
+}
+
+func TestRestrictRepeatedBlocks(t *testing.T) {
+	var cfg RepeatedConfig
+	err := hclconfig.LoadWithBytes(&cfg, "config.hcl", []byte(`
+rule {
+  action = "allow"
+}
+
+rule {
+  action = "deny"
+}
+
+target "hoge" {
+  port = 8080
+}
+
+target "hoge" {
+  port = 8081
+}
+`))
+	require.NoError(t, err)
+	require.EqualValues(t, []RepeatedRule{
+		{Action: "allow", Range: "config.hcl:3,3-19"},
+		{Action: "deny", Range: "config.hcl:7,3-18"},
+	}, cfg.Rules)
+	require.EqualValues(t, []*RepeatedTarget{
+		{Name: "hoge", Port: 8080, Range: "config.hcl:11,3-14"},
+		{Name: "hoge", Port: 8081, Range: "config.hcl:15,3-14"},
+	}, cfg.Targets)
+}
+
+type RestrictedEndpoint struct {
+	URL string `cty:"url"`
+
+	Range string
+}
+
+func (e *RestrictedEndpoint) Restrict(content *hcl.BodyContent, ctx *hcl.EvalContext) hcl.Diagnostics {
+	e.Range = content.MissingItemRange.String()
+	if !strings.HasPrefix(e.URL, "https://") {
+		return hcl.Diagnostics{hclconfig.NewDiagnosticError(
+			"Invalid url",
+			"url must be https",
+			content.MissingItemRange.Ptr(),
+		)}
+	}
+	return nil
+}
+
+type RemainConfig struct {
+	Name  string       `hcl:"name"`
+	Extra *RemainExtra `hcl:",remain"`
+}
+
+type RemainExtra struct {
+	Retry int `hcl:"retry,optional"`
+
+	Range string
+}
+
+func (e *RemainExtra) Restrict(content *hcl.BodyContent, ctx *hcl.EvalContext) hcl.Diagnostics {
+	e.Range = hclconfig.AttributeRange(content, "retry").String()
+	return nil
+}
+
+func TestRestrictMapsAndRemain(t *testing.T) {
+	endpoints := map[string]*RestrictedEndpoint{}
+	err := hclconfig.LoadWithBytes(&endpoints, "config.hcl", []byte(`
+hoge = { url = "https://example.com" }
+fuga = { url = "https://example.net" }
+`))
+	require.NoError(t, err)
+	require.EqualValues(t, map[string]*RestrictedEndpoint{
+		"hoge": {URL: "https://example.com", Range: "config.hcl:2,8-39"},
+		"fuga": {URL: "https://example.net", Range: "config.hcl:3,8-39"},
+	}, endpoints)
+
+	var diags []string
+	loader := hclconfig.New()
+	loader.DiagnosticWriter(hclconfig.DiagnosticWriterFunc(func(diag *hcl.Diagnostic) error {
+		diags = append(diags, diag.Error())
+		return nil
+	}))
+	err = loader.LoadWithBytes(&endpoints, "config.hcl", []byte(`
+hoge = { url = "http://example.com" }
+`))
+	require.Error(t, err)
+	require.EqualValues(t, []string{
+		`config.hcl:2,8-38: Invalid url; url must be https`,
+	}, diags)
+
+	var cfg RemainConfig
+	err = hclconfig.LoadWithBytes(&cfg, "config.hcl", []byte(`
+name  = "hoge"
+retry = 3
+`))
+	require.NoError(t, err)
+	require.EqualValues(t, &RemainExtra{Retry: 3, Range: "config.hcl:3,1-10"}, cfg.Extra)
+}
+
+func TestRestrictHelpers(t *testing.T) {
+	schema := &hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{
+			{Name: "mode"},
+			{Name: "port"},
+			{Name: "token"},
+			{Name: "password"},
+			{Name: "user"},
+		},
+		Blocks: []hcl.BlockHeaderSchema{
+			{Type: "service", LabelNames: []string{"name"}},
+		},
+	}
+	src := `
+mode     = "public"
+port     = 70000
+token    = "xxx"
+password = "yyy"
+
+service "hoge" {}
+service "Fuga" {}
+`
+	cases := []struct {
+		name     string
+		restrict func(content *hcl.BodyContent) hcl.Diagnostics
+		expected string
+	}{
+		{
+			name: "one_of",
+			restrict: func(content *hcl.BodyContent) hcl.Diagnostics {
+				return hclconfig.RestrictOneOf(content, "mode", "readonly", "readwrite")
+			},
+			expected: `temp.hcl:2,12-20: Invalid mode; mode must be one of "readonly", "readwrite", not "public"`,
+		},
+		{
+			name: "one_of_omitted",
+			restrict: func(content *hcl.BodyContent) hcl.Diagnostics {
+				return hclconfig.RestrictOneOf(content, "user", "hoge")
+			},
+			expected: "no diagnostics",
+		},
+		{
+			name: "mutually_exclusive",
+			restrict: func(content *hcl.BodyContent) hcl.Diagnostics {
+				return hclconfig.RestrictMutuallyExclusive(content, "password", "token")
+			},
+			expected: `temp.hcl:5,1-17: Conflicting attributes; Only one of "password", "token" can be set. "password" conflicts with "token" defined at temp.hcl:4,1-17`,
+		},
+		{
+			name: "required_with",
+			restrict: func(content *hcl.BodyContent) hcl.Diagnostics {
+				return hclconfig.RestrictRequiredWith(content, "password", "user")
+			},
+			expected: `temp.hcl:5,1-17: Missing required attribute; "user" is required when "password" is set.`,
+		},
+		{
+			name: "at_least_one_of",
+			restrict: func(content *hcl.BodyContent) hcl.Diagnostics {
+				return hclconfig.RestrictAtLeastOneOf(content, "user", "token")
+			},
+			expected: "no diagnostics",
+		},
+		{
+			name: "at_least_one_of_missing",
+			restrict: func(content *hcl.BodyContent) hcl.Diagnostics {
+				return hclconfig.RestrictAtLeastOneOf(content, "user")
+			},
+			expected: `temp.hcl:1,1-1: Missing required attribute; At least one of "user" is required.`,
+		},
+		{
+			name: "block_count_max",
+			restrict: func(content *hcl.BodyContent) hcl.Diagnostics {
+				return hclconfig.RestrictBlockCount(content, "service", 0, 1)
+			},
+			expected: `temp.hcl:8,1-15: Too many "service" blocks; At most 1 "service" blocks are allowed, but 2 were defined.`,
+		},
+		{
+			name: "block_count_min",
+			restrict: func(content *hcl.BodyContent) hcl.Diagnostics {
+				return hclconfig.RestrictBlockCount(content, "service", 3, -1)
+			},
+			expected: `temp.hcl:1,1-1: Too few "service" blocks; At least 3 "service" blocks are required, but 2 were defined.`,
+		},
+		{
+			name: "label_pattern",
+			restrict: func(content *hcl.BodyContent) hcl.Diagnostics {
+				return hclconfig.RestrictLabelPattern(content, regexp.MustCompile(`^[a-z]+$`), "service")
+			},
+			expected: `temp.hcl:8,9-15: Invalid "service" block label; The label "Fuga" must match ^[a-z]+$.`,
+		},
+		{
+			name: "attribute_type",
+			restrict: func(content *hcl.BodyContent) hcl.Diagnostics {
+				return hclconfig.RestrictAttributeType(content, "mode", cty.Bool)
+			},
+			expected: `temp.hcl:2,12-20: Incorrect attribute value type; Inappropriate value for attribute "mode": a bool is required.`,
+		},
+		{
+			name: "number_range",
+			restrict: func(content *hcl.BodyContent) hcl.Diagnostics {
+				return hclconfig.RestrictNumberRange(content, "port", 1, 65535)
+			},
+			expected: `temp.hcl:3,12-17: Invalid port; port must be at most 65535, not 70000`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			file, diags := hclsyntax.ParseConfig([]byte(src), "temp.hcl", hcl.InitialPos)
+			require.False(t, diags.HasErrors())
+			content, diags := file.Body.Content(schema)
+			require.False(t, diags.HasErrors())
+			diags = c.restrict(content)
+			require.EqualValues(t, c.expected, diags.Error())
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package hclconfig
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+var victimExpr hcl.Expression
+var victimBody hcl.Body
+
+var exprType = reflect.TypeOf(&victimExpr).Elem()
+var bodyType = reflect.TypeOf(&victimBody).Elem()
+var attrType = reflect.TypeOf((*hcl.Attribute)(nil))
+var attrsType = reflect.TypeOf(hcl.Attributes(nil))
+
+// impliedBodySchema is the same as gohcl.ImpliedBodySchema.
+// It produces a hcl.BodySchema derived from the hcl tags of the struct, and reports whether the struct has a remain field.
+func impliedBodySchema(val interface{}) (schema *hcl.BodySchema, partial bool) {
+	ty := reflect.TypeOf(val)
+	if ty.Kind() == reflect.Pointer {
+		ty = ty.Elem()
+	}
+	if ty.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("given value must be struct, not %T", val))
+	}
+
+	tags := getFieldTags(ty)
+	attrNames := make([]string, 0, len(tags.Attributes))
+	for n := range tags.Attributes {
+		attrNames = append(attrNames, n)
+	}
+	sort.Strings(attrNames)
+	attrSchemas := make([]hcl.AttributeSchema, 0, len(attrNames))
+	for _, n := range attrNames {
+		field := ty.FieldByIndex(tags.Attributes[n])
+		var required bool
+		switch {
+		case field.Type.AssignableTo(exprType):
+			// absence can be indicated via a null value expression.
+			required = false
+		case field.Type.Kind() != reflect.Pointer && !tags.Optional[n]:
+			_, hasDefault := field.Tag.Lookup("default")
+			required = !hasDefault
+		}
+		attrSchemas = append(attrSchemas, hcl.AttributeSchema{
+			Name:     n,
+			Required: required,
+		})
+	}
+
+	blockNames := make([]string, 0, len(tags.Blocks))
+	for n := range tags.Blocks {
+		blockNames = append(blockNames, n)
+	}
+	sort.Strings(blockNames)
+	blockSchemas := make([]hcl.BlockHeaderSchema, 0, len(blockNames))
+	for _, n := range blockNames {
+		field := ty.FieldByIndex(tags.Blocks[n])
+		fty, depth := blockElemType(field.Type)
+		if fty.Kind() != reflect.Struct {
+			panic(fmt.Sprintf(
+				"hcl 'block' tag kind cannot be applied to %s field %s: struct required", field.Type.String(), field.Name,
+			))
+		}
+		labelNames := blockLabelNames(fty, depth)
+		if depth > 0 && len(labelNames) != depth {
+			panic(fmt.Sprintf(
+				"hcl 'block' tag kind cannot be applied to %s field %s: %d labels required, but %s has %d", field.Type.String(), field.Name, depth, fty.String(), len(labelNames),
+			))
+		}
+		blockSchemas = append(blockSchemas, hcl.BlockHeaderSchema{
+			Type:       n,
+			LabelNames: labelNames,
+		})
+	}
+
+	schema = &hcl.BodySchema{
+		Attributes: attrSchemas,
+		Blocks:     blockSchemas,
+	}
+	return schema, tags.Remain != nil
+}
+
+// blockElemType returns the struct type of the block field, and the depth of the maps keyed by labels.
+func blockElemType(ty reflect.Type) (reflect.Type, int) {
+	var depth int
+	if ty.Kind() == reflect.Slice {
+		ty = ty.Elem()
+	} else {
+		for ty.Kind() == reflect.Map && ty.Key().Kind() == reflect.String {
+			ty = ty.Elem()
+			depth++
+		}
+	}
+	if ty.Kind() == reflect.Pointer {
+		ty = ty.Elem()
+	}
+	return ty, depth
+}
+
+// blockLabelNames returns the names of the label fields.
+// If the struct has no label fields, depth names are generated for the block decoded into maps.
+func blockLabelNames(ty reflect.Type, depth int) []string {
+	tags := getFieldTags(ty)
+	if len(tags.Labels) == 0 {
+		if depth == 0 {
+			return nil
+		}
+		labelNames := make([]string, depth)
+		for i := range labelNames {
+			labelNames[i] = fmt.Sprintf("label%d", i+1)
+		}
+		return labelNames
+	}
+	labelNames := make([]string, len(tags.Labels))
+	for i, l := range tags.Labels {
+		labelNames[i] = l.Name
+	}
+	return labelNames
+}
+
+type fieldTags struct {
+	Attributes map[string][]int
+	Blocks     map[string][]int
+	Labels     []labelField
+	Remain     []int
+	Body       []int
+	Range      []int
+	Optional   map[string]bool
+	// Embedded is the embedded structs flattened into the tags.
+	Embedded [][]int
+}
+
+type labelField struct {
+	FieldIndex []int
+	Name       string
+}
+
+// getFieldTags returns the hcl tags of the struct fields, as the index sequences for reflect.Value.FieldByIndex.
+// The fields of the embedded structs without hcl tags are flattened, and the outer fields take precedence.
+func getFieldTags(ty reflect.Type) *fieldTags {
+	ret := &fieldTags{
+		Attributes: map[string][]int{},
+		Blocks:     map[string][]int{},
+		Optional:   map[string]bool{},
+	}
+	var embedded []*fieldTags
+	num := ty.NumField()
+	for i := 0; i < num; i++ {
+		field := ty.Field(i)
+		index := []int{i}
+		tag := field.Tag.Get("hcl")
+		if tag == "" {
+			if embeddedType, ok := embeddedStructType(field); ok {
+				tags := getFieldTags(embeddedType)
+				tags.prependIndex(i)
+				ret.Embedded = append(ret.Embedded, index)
+				ret.Embedded = append(ret.Embedded, tags.Embedded...)
+				ret.Labels = append(ret.Labels, tags.Labels...)
+				embedded = append(embedded, tags)
+			}
+			continue
+		}
+		name, kind := getHCLTagNameKind(tag)
+		switch kind {
+		case "attr":
+			ret.Attributes[name] = index
+		case "block":
+			ret.Blocks[name] = index
+		case "label":
+			ret.Labels = append(ret.Labels, labelField{
+				FieldIndex: index,
+				Name:       name,
+			})
+		case "remain":
+			if ret.Remain != nil {
+				panic("only one 'remain' tag is permitted")
+			}
+			ret.Remain = index
+		case "body":
+			if ret.Body != nil {
+				panic("only one 'body' tag is permitted")
+			}
+			ret.Body = index
+		case "range":
+			if ret.Range != nil {
+				panic("only one 'range' tag is permitted")
+			}
+			ret.Range = index
+		case "optional":
+			ret.Attributes[name] = index
+			ret.Optional[name] = true
+		default:
+			panic(fmt.Sprintf("invalid hcl field tag kind %q on %s %q", kind, field.Type.String(), field.Name))
+		}
+	}
+	for _, tags := range embedded {
+		for name, index := range tags.Attributes {
+			if _, ok := ret.Attributes[name]; !ok {
+				ret.Attributes[name] = index
+				ret.Optional[name] = tags.Optional[name]
+			}
+		}
+		for name, index := range tags.Blocks {
+			if _, ok := ret.Blocks[name]; !ok {
+				ret.Blocks[name] = index
+			}
+		}
+		if ret.Remain == nil {
+			ret.Remain = tags.Remain
+		}
+		if ret.Body == nil {
+			ret.Body = tags.Body
+		}
+		if ret.Range == nil {
+			ret.Range = tags.Range
+		}
+	}
+	return ret
+}
+
+func (tags *fieldTags) prependIndex(i int) {
+	prepend := func(index []int) []int {
+		if index == nil {
+			return nil
+		}
+		return append([]int{i}, index...)
+	}
+	for name, index := range tags.Attributes {
+		tags.Attributes[name] = prepend(index)
+	}
+	for name, index := range tags.Blocks {
+		tags.Blocks[name] = prepend(index)
+	}
+	for j := range tags.Labels {
+		tags.Labels[j].FieldIndex = prepend(tags.Labels[j].FieldIndex)
+	}
+	for j := range tags.Embedded {
+		tags.Embedded[j] = prepend(tags.Embedded[j])
+	}
+	tags.Remain = prepend(tags.Remain)
+	tags.Body = prepend(tags.Body)
+	tags.Range = prepend(tags.Range)
+}
+
+// sortedFieldNames returns the names in the order of the fields.
+func sortedFieldNames(indexes map[string][]int) []string {
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := indexes[names[i]], indexes[names[j]]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return names
+}
+
+// embeddedStructType returns the struct type of the embedded field, that is a struct or a pointer to a struct.
+func embeddedStructType(field reflect.StructField) (reflect.Type, bool) {
+	if !field.Anonymous {
+		return nil, false
+	}
+	ty := field.Type
+	if ty.Kind() == reflect.Pointer {
+		ty = ty.Elem()
+	}
+	if ty.Kind() != reflect.Struct {
+		return nil, false
+	}
+	if !field.IsExported() && field.Type.Kind() == reflect.Pointer {
+		return nil, false
+	}
+	return ty, true
+}
+
+// fieldByIndex returns the field of the index sequence.
+// The nil embedded pointers on the way are allocated if alloc is true, otherwise ok is false.
+func fieldByIndex(val reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && val.Kind() == reflect.Pointer {
+			if val.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				val.Set(reflect.New(val.Type().Elem()))
+			}
+			val = val.Elem()
+		}
+		val = val.Field(x)
+	}
+	return val, true
+}
+
+// implementations returns val if it implements the interface, otherwise the embedded structs implementing it.
+// The embedded structs are searched recursively, in the same way as the promoted methods.
+func implementations(val reflect.Value, iface reflect.Type) []interface{} {
+	if val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	if !val.CanAddr() || val.Kind() != reflect.Struct {
+		return nil
+	}
+	if val.Addr().Type().Implements(iface) {
+		return []interface{}{val.Addr().Interface()}
+	}
+	var ret []interface{}
+	ty := val.Type()
+	num := ty.NumField()
+	for i := 0; i < num; i++ {
+		field := ty.Field(i)
+		if field.Tag.Get("hcl") != "" || !field.IsExported() {
+			continue
+		}
+		if _, ok := embeddedStructType(field); ok {
+			ret = append(ret, implementations(val.Field(i), iface)...)
+		}
+	}
+	return ret
+}
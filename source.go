@@ -0,0 +1,77 @@
+package hclconfig
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// Source is the location of a decoded block in the configuration.
+// It is filled into the field with the `hcl:",range"` tag. The tag can also be applied to a hcl.Range field, which is filled with DefRange.
+type Source struct {
+	BlockType string
+	Labels    []string
+	Filename  string
+	DefRange  hcl.Range
+	// Attributes is the ranges of the attributes in the body, keyed by the attribute names.
+	Attributes map[string]hcl.Range
+}
+
+// Name returns the block type and the labels joined with dots, such as "service.http.hoge".
+func (s Source) Name() string {
+	return strings.Join(append([]string{s.BlockType}, s.Labels...), ".")
+}
+
+// AttributeRange returns the range of the attribute, or DefRange if the attribute is omitted.
+func (s Source) AttributeRange(name string) hcl.Range {
+	if r, ok := s.Attributes[name]; ok {
+		return r
+	}
+	return s.DefRange
+}
+
+// String returns the name and the location, such as "service.http.hoge defined at config.hcl:4".
+func (s Source) String() string {
+	location := fmt.Sprintf("%s:%d", s.Filename, s.DefRange.Start.Line)
+	if s.BlockType == "" {
+		return fmt.Sprintf("defined at %s", location)
+	}
+	return fmt.Sprintf("%s defined at %s", s.Name(), location)
+}
+
+var sourceType = reflect.TypeOf(Source{})
+var rangeType = reflect.TypeOf(hcl.Range{})
+
+// newSource returns the Source of the block. block is nil for the root body.
+func newSource(block *hcl.Block, body hcl.Body, content *hcl.BodyContent) Source {
+	var s Source
+	if block != nil {
+		s.BlockType = block.Type
+		s.Labels = append([]string{}, block.Labels...)
+		s.DefRange = block.DefRange
+	} else {
+		s.DefRange = body.MissingItemRange()
+	}
+	s.Filename = s.DefRange.Filename
+	s.Attributes = make(map[string]hcl.Range, len(content.Attributes))
+	for name, attr := range content.Attributes {
+		s.Attributes[name] = attr.Range
+	}
+	return s
+}
+
+// setSource sets the source into the range field.
+func setSource(field reflect.StructField, fieldV reflect.Value, s Source) {
+	switch field.Type {
+	case sourceType:
+		fieldV.Set(reflect.ValueOf(s))
+	case reflect.PointerTo(sourceType):
+		fieldV.Set(reflect.ValueOf(&s))
+	case rangeType:
+		fieldV.Set(reflect.ValueOf(s.DefRange))
+	default:
+		panic(fmt.Sprintf("hcl 'range' tag kind cannot be applied to %s field %s: hclconfig.Source or hcl.Range required", field.Type.String(), field.Name))
+	}
+}
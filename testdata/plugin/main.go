@@ -0,0 +1,123 @@
+// This is a function plugin for testing, it speaks the plugin protocol with only encoding/json.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type request struct {
+	Method string  `json:"method"`
+	Name   string  `json:"name"`
+	Args   []value `json:"args"`
+}
+
+type value struct {
+	Value json.RawMessage `json:"value"`
+	Type  json.RawMessage `json:"type,omitempty"`
+}
+
+var handshake = map[string]interface{}{
+	"protocol_version": 1,
+	"functions": []map[string]interface{}{
+		{
+			"name":        "lookup",
+			"description": "Returns the value of the key.",
+			"params": []map[string]interface{}{
+				{"name": "key", "type": "string"},
+			},
+			"return_type": "string",
+		},
+		{
+			"name": "join_all",
+			"params": []map[string]interface{}{
+				{"name": "separator", "type": "string"},
+			},
+			"var_param":   map[string]interface{}{"name": "strs", "type": "string"},
+			"return_type": "string",
+		},
+		{
+			"name":        "wrong_type",
+			"params":      []map[string]interface{}{},
+			"return_type": "number",
+		},
+		{
+			"name":        "hang",
+			"params":      []map[string]interface{}{},
+			"return_type": "string",
+		},
+		{
+			"name": "echo",
+			"params": []map[string]interface{}{
+				{"name": "value", "type": "dynamic", "allow_null": true},
+			},
+			"return_type": "dynamic",
+		},
+	},
+}
+
+var table = map[string]string{
+	"hoge": "fuga",
+}
+
+func call(req *request) interface{} {
+	switch req.Name {
+	case "lookup":
+		var key string
+		if err := json.Unmarshal(req.Args[0].Value, &key); err != nil {
+			return map[string]string{"error": err.Error()}
+		}
+		v, ok := table[key]
+		if !ok {
+			return map[string]string{"error": fmt.Sprintf("%s not found", key)}
+		}
+		return map[string]interface{}{"value": v}
+	case "join_all":
+		strs := make([]string, 0, len(req.Args))
+		for _, arg := range req.Args {
+			var s string
+			if err := json.Unmarshal(arg.Value, &s); err != nil {
+				return map[string]string{"error": err.Error()}
+			}
+			strs = append(strs, s)
+		}
+		return map[string]interface{}{"value": strings.Join(strs[1:], strs[0]), "type": "string"}
+	case "echo":
+		return req.Args[0]
+	case "wrong_type":
+		return map[string]interface{}{"value": "hoge", "type": "string"}
+	case "hang":
+		fmt.Fprintln(os.Stderr, "hanging")
+		time.Sleep(time.Hour)
+	}
+	return map[string]string{"error": fmt.Sprintf("unknown function %s", req.Name)}
+}
+
+func main() {
+	scanner := bufio.NewScanner(os.Stdin)
+	encoder := json.NewEncoder(os.Stdout)
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		var resp interface{}
+		switch req.Method {
+		case "handshake":
+			resp = handshake
+		case "call":
+			resp = call(&req)
+		default:
+			resp = map[string]string{"error": fmt.Sprintf("unknown method %s", req.Method)}
+		}
+		if err := encoder.Encode(resp); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
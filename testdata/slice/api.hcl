@@ -0,0 +1,2 @@
+name = "api"
+port = 8080
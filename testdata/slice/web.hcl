@@ -0,0 +1,6 @@
+locals {
+  port = 8000
+}
+
+name = "web"
+port = local.port + 80
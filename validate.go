@@ -0,0 +1,211 @@
+package hclconfig
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// validateRule is a rule of the validate tag, such as `min=1`.
+type validateRule struct {
+	name  string
+	param string
+}
+
+// parseValidateTag parses the validate tag.
+// Rules are separated by commas. The regexp rule must be the last, because its pattern may contain commas.
+func parseValidateTag(tag string) []validateRule {
+	var rules []validateRule
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(tag, "regexp=") {
+			part, tag = tag, ""
+		} else if comma := strings.Index(tag, ","); comma != -1 {
+			part, tag = tag[:comma], tag[comma+1:]
+		} else {
+			part, tag = tag, ""
+		}
+		rule := validateRule{name: part}
+		if eq := strings.Index(part, "="); eq != -1 {
+			rule.name, rule.param = part[:eq], part[eq+1:]
+		}
+		switch rule.name {
+		case "required", "url", "hostport", "unique":
+		case "min", "max", "len", "oneof", "regexp":
+			if rule.param == "" {
+				panic(fmt.Sprintf("validate rule %q requires a parameter", rule.name))
+			}
+		default:
+			panic(fmt.Sprintf("unknown validate rule %q", rule.name))
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// validateStruct checks the attributes of the decoded struct with the validate tags.
+// The rules except required are skipped for the attributes omitted in the body.
+func validateStruct(content *hcl.BodyContent, val reflect.Value) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	ty := val.Type()
+	tags := getFieldTags(ty)
+	for _, name := range sortedFieldNames(tags.Attributes) {
+		index := tags.Attributes[name]
+		tag, ok := ty.FieldByIndex(index).Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+		fieldV, ok := fieldByIndex(val, index, false)
+		if !ok {
+			continue
+		}
+		_, exists := content.Attributes[name]
+		for _, rule := range parseValidateTag(tag) {
+			if !exists && rule.name != "required" {
+				continue
+			}
+			if detail, ok := validateValue(rule, fieldV); !ok {
+				diags = append(diags, NewDiagnosticError(
+					fmt.Sprintf("Invalid %s", name),
+					fmt.Sprintf("%s %s", name, detail),
+					AttributeRange(content, name),
+				))
+				break
+			}
+		}
+	}
+	return diags
+}
+
+// validateValue checks the value with the rule, and returns the reason if it is not satisfied.
+func validateValue(rule validateRule, rv reflect.Value) (string, bool) {
+	if rule.name == "required" {
+		if rv.IsZero() {
+			return "is required", false
+		}
+		return "", true
+	}
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return "", true
+		}
+		rv = rv.Elem()
+	}
+	switch rule.name {
+	case "min", "max":
+		return validateRange(rule, rv)
+	case "len":
+		n, err := strconv.Atoi(rule.param)
+		if err != nil {
+			panic(fmt.Sprintf("invalid len parameter %q: %s", rule.param, err))
+		}
+		if l, ok := valueLength(rv); ok && l != n {
+			return fmt.Sprintf("must have a length of %d, not %d", n, l), false
+		}
+	case "oneof":
+		options := strings.Fields(rule.param)
+		str := fmt.Sprint(rv.Interface())
+		for _, option := range options {
+			if str == option {
+				return "", true
+			}
+		}
+		return fmt.Sprintf("must be one of %s, not %q", strings.Join(options, ", "), str), false
+	case "regexp":
+		re, err := regexp.Compile(rule.param)
+		if err != nil {
+			panic(fmt.Sprintf("invalid regexp parameter %q: %s", rule.param, err))
+		}
+		if rv.Kind() == reflect.String && !re.MatchString(rv.String()) {
+			return fmt.Sprintf("must match %s, not %q", rule.param, rv.String()), false
+		}
+	case "url":
+		if rv.Kind() != reflect.String || rv.String() == "" {
+			return "", true
+		}
+		u, err := url.Parse(rv.String())
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Sprintf("must be an absolute URL, not %q", rv.String()), false
+		}
+	case "hostport":
+		if rv.Kind() != reflect.String || rv.String() == "" {
+			return "", true
+		}
+		if _, _, err := net.SplitHostPort(rv.String()); err != nil {
+			return fmt.Sprintf("must be a host:port, not %q: %s", rv.String(), err), false
+		}
+	case "unique":
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return "", true
+		}
+		seen := make(map[string]bool, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			key := fmt.Sprintf("%#v", rv.Index(i).Interface())
+			if seen[key] {
+				return fmt.Sprintf("must have unique elements, %v is duplicated", rv.Index(i).Interface()), false
+			}
+			seen[key] = true
+		}
+	}
+	return "", true
+}
+
+// validateRange checks min and max. Numbers are compared by value, and the others by length.
+func validateRange(rule validateRule, rv reflect.Value) (string, bool) {
+	var actual float64
+	verb := "be"
+	switch {
+	case rv.Type() == durationType:
+		d, err := time.ParseDuration(rule.param)
+		if err != nil {
+			panic(fmt.Sprintf("invalid %s parameter %q: %s", rule.name, rule.param, err))
+		}
+		if (rule.name == "min" && time.Duration(rv.Int()) < d) || (rule.name == "max" && time.Duration(rv.Int()) > d) {
+			return fmt.Sprintf("must be %s %s, not %s", comparisonWord(rule.name), d, time.Duration(rv.Int())), false
+		}
+		return "", true
+	case rv.CanInt():
+		actual = float64(rv.Int())
+	case rv.CanUint():
+		actual = float64(rv.Uint())
+	case rv.CanFloat():
+		actual = rv.Float()
+	default:
+		l, ok := valueLength(rv)
+		if !ok {
+			return "", true
+		}
+		actual = float64(l)
+		verb = "have a length of"
+	}
+	limit, err := strconv.ParseFloat(rule.param, 64)
+	if err != nil {
+		panic(fmt.Sprintf("invalid %s parameter %q: %s", rule.name, rule.param, err))
+	}
+	if (rule.name == "min" && actual < limit) || (rule.name == "max" && actual > limit) {
+		return fmt.Sprintf("must %s %s %s, not %s", verb, comparisonWord(rule.name), rule.param, strconv.FormatFloat(actual, 'f', -1, 64)), false
+	}
+	return "", true
+}
+
+func comparisonWord(rule string) string {
+	if rule == "min" {
+		return "at least"
+	}
+	return "at most"
+}
+
+func valueLength(rv reflect.Value) (int, bool) {
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,149 @@
+package hclconfig
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// Validator is an interface for validation with the whole decoded config.
+// Validate is called after decoding and restricting the whole config, if there are no errors.
+type Validator interface {
+	Validate(vctx *ValidationContext) hcl.Diagnostics
+}
+
+// ValidationContext is the context of the block passed to Validate.
+type ValidationContext struct {
+	// Root is the pointer to the decoded config passed to Load or DecodeBody.
+	Root interface{}
+	// Parents is the pointers to the enclosing structs, from the root to the immediate parent.
+	Parents []interface{}
+	// Path is the block types and labels from the root, such as ["service", "http", "hoge"].
+	Path []string
+	// Block is the block decoded into the value, or nil for the root.
+	Block *hcl.Block
+	// Content is the content of the body.
+	Content     *hcl.BodyContent
+	EvalContext *hcl.EvalContext
+}
+
+// Parent returns the pointer to the immediate parent, or nil for the root.
+func (vctx *ValidationContext) Parent() interface{} {
+	if len(vctx.Parents) == 0 {
+		return nil
+	}
+	return vctx.Parents[len(vctx.Parents)-1]
+}
+
+// Name returns the path joined with dots, such as "service.http.hoge".
+func (vctx *ValidationContext) Name() string {
+	return strings.Join(vctx.Path, ".")
+}
+
+// DefRange returns the definition range of the block, or the missing item range of the root body.
+func (vctx *ValidationContext) DefRange() hcl.Range {
+	if vctx.Block != nil {
+		return vctx.Block.DefRange
+	}
+	return vctx.Content.MissingItemRange
+}
+
+// AttributeRange returns the range of the attribute, or the missing item range if it is omitted.
+func (vctx *ValidationContext) AttributeRange(name string) *hcl.Range {
+	return AttributeRange(vctx.Content, name)
+}
+
+var validatorType = reflect.TypeOf((*Validator)(nil)).Elem()
+
+// runValidators calls Validate of the decoded values, with the bodies paired in the same way as the restrictor.
+func runValidators(body hcl.Body, ctx *hcl.EvalContext, cfg interface{}) hcl.Diagnostics {
+	w := &validationWalker{
+		root: cfg,
+		ctx:  ctx,
+	}
+	w.walk(body, nil, reflect.ValueOf(cfg), nil, nil)
+	return w.diags
+}
+
+type validationWalker struct {
+	root  interface{}
+	ctx   *hcl.EvalContext
+	diags hcl.Diagnostics
+}
+
+func (w *validationWalker) walk(body hcl.Body, block *hcl.Block, val reflect.Value, parents []interface{}, path []string) {
+	for val.Kind() == reflect.Pointer || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct || !val.CanAddr() {
+		return
+	}
+	schema, partial := impliedBodySchema(val.Interface())
+	var content *hcl.BodyContent
+	var leftovers hcl.Body
+	if partial {
+		content, leftovers, _ = body.PartialContent(schema)
+	} else {
+		content, _ = body.Content(schema)
+	}
+	if content == nil {
+		return
+	}
+	vctx := &ValidationContext{
+		Root:        w.root,
+		Parents:     parents,
+		Path:        path,
+		Block:       block,
+		Content:     content,
+		EvalContext: w.ctx,
+	}
+	for _, validator := range implementations(val, validatorType) {
+		w.diags = append(w.diags, validator.(Validator).Validate(vctx)...)
+	}
+
+	children := append(append([]interface{}{}, parents...), val.Addr().Interface())
+	tags := getFieldTags(val.Type())
+	if tags.Remain != nil && leftovers != nil {
+		if fieldV, ok := fieldByIndex(val, tags.Remain, false); ok {
+			if p, ok := asPolymorphic(fieldV); ok {
+				w.walk(leftovers, block, p.value(), children, path)
+			} else if fieldV.Kind() == reflect.Struct || fieldV.Kind() == reflect.Pointer {
+				w.walk(leftovers, block, fieldV, children, path)
+			}
+		}
+	}
+	blocksByType := content.Blocks.ByType()
+	for _, tagName := range sortedFieldNames(tags.Blocks) {
+		fieldV, ok := fieldByIndex(val, tags.Blocks[tagName], false)
+		if !ok {
+			continue
+		}
+		for i, b := range blocksByType[tagName] {
+			blockPath := append(append(append([]string{}, path...), b.Type), b.Labels...)
+			switch fieldV.Kind() {
+			case reflect.Map:
+				m, leaf, ok := mapElemByLabels(fieldV, b.Labels)
+				if !ok {
+					continue
+				}
+				// map elements are not addressable, so the copy is validated and stored back.
+				v := reflect.New(m.Type().Elem()).Elem()
+				v.Set(m.MapIndex(leaf))
+				w.walk(b.Body, b, v, children, blockPath)
+				m.SetMapIndex(leaf, v)
+			case reflect.Slice:
+				if i < fieldV.Len() {
+					w.walk(b.Body, b, fieldV.Index(i), children, blockPath)
+				}
+			default:
+				if i == 0 {
+					w.walk(b.Body, b, fieldV, children, blockPath)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package hclconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// NumberMode is the Go representation of cty numbers in ValueToInterface.
+type NumberMode int
+
+const (
+	// NumberNative converts integers fitting in int64 to int64, the other numbers to float64.
+	// Integers not fitting in int64 are converted to *big.Float, so they are not truncated.
+	NumberNative NumberMode = iota
+	// NumberJSON converts numbers to json.Number, which keeps the decimal representation.
+	NumberJSON
+	// NumberBigFloat converts numbers to *big.Float.
+	NumberBigFloat
+)
+
+// InterfaceOptions is the options of ValueToInterface.
+type InterfaceOptions struct {
+	// Number is the representation of numbers.
+	Number NumberMode
+	// PreserveMarks wraps the marked values in MarkedValue. If false, the marks are removed.
+	PreserveMarks bool
+}
+
+// MarkedValue is a marked value converted by ValueToInterface with PreserveMarks.
+type MarkedValue struct {
+	Value interface{}
+	Marks cty.ValueMarks
+}
+
+// ValueToInterface converts the cty value to the Go value.
+// Strings, bools, objects, maps, lists, tuples and sets are converted to string, bool, map[string]interface{} and []interface{}.
+// Numbers are converted in the mode of the options, and capsules to the encapsulated pointers.
+// Null values are converted to nil, and unknown values are reported as errors.
+func ValueToInterface(value cty.Value, opts InterfaceOptions) (interface{}, error) {
+	return valueToInterface(value, cty.Path{}, opts)
+}
+
+func valueToInterface(value cty.Value, path cty.Path, opts InterfaceOptions) (interface{}, error) {
+	if value.IsMarked() {
+		unmarked, marks := value.Unmark()
+		v, err := valueToInterface(unmarked, path, opts)
+		if err != nil || !opts.PreserveMarks {
+			return v, err
+		}
+		return MarkedValue{Value: v, Marks: marks}, nil
+	}
+	if !value.IsKnown() {
+		return nil, path.NewErrorf("%svalue is unknown", pathPrefix(path))
+	}
+	if value.IsNull() {
+		return nil, nil
+	}
+	t := value.Type()
+	switch {
+	case t == cty.String:
+		return value.AsString(), nil
+	case t == cty.Bool:
+		return value.True(), nil
+	case t == cty.Number:
+		return numberToInterface(value.AsBigFloat(), opts.Number), nil
+	case t.IsCapsuleType():
+		return value.EncapsulatedValue(), nil
+	case t.IsObjectType() || t.IsMapType():
+		m := make(map[string]interface{}, value.LengthInt())
+		for it := value.ElementIterator(); it.Next(); {
+			k, v := it.Element()
+			elemPath := path.Index(k)
+			if t.IsObjectType() {
+				elemPath = path.GetAttr(k.AsString())
+			}
+			elem, err := valueToInterface(v, elemPath, opts)
+			if err != nil {
+				return nil, err
+			}
+			m[k.AsString()] = elem
+		}
+		return m, nil
+	case t.IsListType() || t.IsTupleType() || t.IsSetType():
+		s := make([]interface{}, 0, value.LengthInt())
+		for it := value.ElementIterator(); it.Next(); {
+			k, v := it.Element()
+			elem, err := valueToInterface(v, path.Index(k), opts)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, elem)
+		}
+		return s, nil
+	}
+	return nil, path.NewErrorf("%scan not convert %s to Go value", pathPrefix(path), t.FriendlyName())
+}
+
+// pathPrefix returns the path in the HCL index syntax followed by a colon, such as `["tags"][0]: `.
+func pathPrefix(path cty.Path) string {
+	if len(path) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, step := range path {
+		switch s := step.(type) {
+		case cty.GetAttrStep:
+			fmt.Fprintf(&b, ".%s", s.Name)
+		case cty.IndexStep:
+			if s.Key.Type() == cty.String {
+				fmt.Fprintf(&b, "[%q]", s.Key.AsString())
+			} else if s.Key.Type() == cty.Number {
+				fmt.Fprintf(&b, "[%s]", s.Key.AsBigFloat().Text('f', -1))
+			} else {
+				b.WriteString("[...]")
+			}
+		}
+	}
+	return b.String() + ": "
+}
+
+func numberToInterface(bf *big.Float, mode NumberMode) interface{} {
+	switch mode {
+	case NumberJSON:
+		return json.Number(bf.Text('f', -1))
+	case NumberBigFloat:
+		return new(big.Float).Copy(bf)
+	}
+	if bf.IsInt() {
+		if i, acc := bf.Int64(); acc == big.Exact {
+			return i
+		}
+		return new(big.Float).Copy(bf)
+	}
+	f, _ := bf.Float64()
+	return f
+}
+
+// ctyValueToInterface converts the known value with the default options.
+func ctyValueToInterface(value cty.Value) interface{} {
+	v, err := ValueToInterface(value, InterfaceOptions{})
+	if err != nil {
+		panic(fmt.Sprintf("value must be known: %s", err))
+	}
+	return v
+}
@@ -0,0 +1,68 @@
+package hclconfig_test
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mashiike/hclconfig"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+func TestValueToInterface(t *testing.T) {
+	expr, diags := hclsyntax.ParseExpression([]byte(`jsondecode("{\"id\":12345678901234567890,\"rate\":0.1,\"count\":3}")`), "config.hcl", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	value, diags := expr.Value(&hcl.EvalContext{
+		Functions: map[string]function.Function{
+			"jsondecode": stdlib.JSONDecodeFunc,
+		},
+	})
+	require.False(t, diags.HasErrors())
+
+	actual, err := hclconfig.ValueToInterface(value, hclconfig.InterfaceOptions{})
+	require.NoError(t, err)
+	m := actual.(map[string]interface{})
+	require.Equal(t, "12345678901234567890", m["id"].(*big.Float).Text('f', -1))
+	require.Equal(t, 0.1, m["rate"])
+	require.Equal(t, int64(3), m["count"])
+
+	actual, err = hclconfig.ValueToInterface(value, hclconfig.InterfaceOptions{Number: hclconfig.NumberJSON})
+	require.NoError(t, err)
+	require.EqualValues(t, map[string]interface{}{
+		"id":    json.Number("12345678901234567890"),
+		"rate":  json.Number("0.1"),
+		"count": json.Number("3"),
+	}, actual)
+
+	actual, err = hclconfig.ValueToInterface(value.GetAttr("id"), hclconfig.InterfaceOptions{Number: hclconfig.NumberBigFloat})
+	require.NoError(t, err)
+	require.Equal(t, "12345678901234567890", actual.(*big.Float).Text('f', -1))
+
+	_, err = hclconfig.ValueToInterface(cty.ObjectVal(map[string]cty.Value{
+		"tags": cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.UnknownVal(cty.String)}),
+	}), hclconfig.InterfaceOptions{})
+	require.EqualError(t, err, `.tags[1]: value is unknown`)
+
+	actual, err = hclconfig.ValueToInterface(cty.ListVal([]cty.Value{
+		cty.StringVal("secret").Mark("sensitive"),
+		cty.StringVal("public"),
+	}), hclconfig.InterfaceOptions{PreserveMarks: true})
+	require.NoError(t, err)
+	require.EqualValues(t, []interface{}{
+		hclconfig.MarkedValue{Value: "secret", Marks: cty.NewValueMarks("sensitive")},
+		"public",
+	}, actual)
+
+	type secret struct{ Token string }
+	capsuleType := cty.Capsule("secret", reflect.TypeOf(secret{}))
+	s := &secret{Token: "hoge"}
+	actual, err = hclconfig.ValueToInterface(cty.CapsuleVal(capsuleType, s), hclconfig.InterfaceOptions{})
+	require.NoError(t, err)
+	require.Same(t, s, actual)
+}
@@ -5,7 +5,6 @@ import (
 	"reflect"
 
 	"github.com/hashicorp/hcl/v2"
-	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/zclconf/go-cty/cty"
 )
 
@@ -34,19 +33,13 @@ func impliedVariables(body hcl.Body, ctx *hcl.EvalContext, val interface{}) (map
 }
 
 func impliedVariablesImpl(body hcl.Body, ctx *hcl.EvalContext, ty reflect.Type) (map[string]cty.Value, bool) {
-	if ty.Kind() == reflect.Slice {
-		ty = ty.Elem()
-	}
-
-	if ty.Kind() == reflect.Ptr {
-		ty = ty.Elem()
-	}
+	ty, _ = blockElemType(ty)
 
 	if ty.Kind() != reflect.Struct {
 		return make(map[string]cty.Value), true
 	}
 
-	schema, partial := gohcl.ImpliedBodySchema(reflect.New(ty).Interface())
+	schema, partial := impliedBodySchema(reflect.New(ty).Interface())
 	var content *hcl.BodyContent
 	var diags hcl.Diagnostics
 	if partial {
@@ -66,17 +59,16 @@ func impliedVariablesImpl(body hcl.Body, ctx *hcl.EvalContext, ty reflect.Type)
 		}
 		variables[attr.Name] = variable
 	}
-
-	blockTypes := make(map[string]reflect.Type, len(content.Blocks))
-	num := ty.NumField()
-	for i := 0; i < num; i++ {
-		field := ty.Field(i)
-		tag := field.Tag.Get("hcl")
-		name, kind := getHCLTagNameKind(tag)
-		if kind != "block" {
-			continue
+	for name, value := range defaultVariables(ty) {
+		if _, ok := content.Attributes[name]; !ok {
+			variables[name] = value
 		}
-		blockTypes[name] = field.Type
+	}
+
+	tags := getFieldTags(ty)
+	blockTypes := make(map[string]reflect.Type, len(tags.Blocks))
+	for name, index := range tags.Blocks {
+		blockTypes[name] = ty.FieldByIndex(index).Type
 	}
 
 	for _, block := range content.Blocks {
@@ -88,6 +80,17 @@ func impliedVariablesImpl(body hcl.Body, ctx *hcl.EvalContext, ty reflect.Type)
 		if !known {
 			isAllKnown = false
 		}
+		if elemType, _ := blockElemType(bty); elemType.Kind() == reflect.Struct {
+			if concrete, ok := polymorphicConcreteType(elemType, block); ok {
+				schema, _ := impliedBodySchema(reflect.New(elemType).Interface())
+				_, remain, _ := block.Body.PartialContent(schema)
+				concreteVariables, known := impliedVariablesImpl(remain, ctx, concrete)
+				if !known {
+					isAllKnown = false
+				}
+				blockVarialbes = mergeVariables(blockVarialbes, concreteVariables)
+			}
+		}
 
 		current := blockVarialbes
 		for i := len(block.Labels) - 1; i >= 0; i-- {